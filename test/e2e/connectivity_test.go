@@ -0,0 +1,421 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+	utilnet "k8s.io/utils/net"
+)
+
+// overhead of the geneve encapsulation OVN leaves room for between the pod MTU and the node MTU
+const geneveOverhead = 100
+
+// pingDFRejectedRe matches the complaints of a ping that does not support the -M option, such as
+// the busybox one of the agnhost image
+var pingDFRejectedRe = regexp.MustCompile(`(?i)invalid option|unrecognized option|invalid -M argument|usage:`)
+
+// pingDFRefusedRe matches the ways iputils ping reports a don't fragment packet exceeding the MTU
+// of the interface or of the path
+var pingDFRefusedRe = regexp.MustCompile(`message too long|Frag needed`)
+
+// pingWithDF pings host from the network namespace of the source pod with payloads of the given
+// size and the don't fragment bit set, so that the packets can neither be fragmented by the pod
+// nor along the path. It runs the iputils ping of the KIND node, since the busybox ping of the
+// agnhost image cannot set the bit, and fails if the ping did not accept the option.
+func pingWithDF(srcPod *v1.Pod, host string, size int) (string, error) {
+	out, err := runInPodNetns(srcPod, "ping", "-M", "do", "-s", strconv.Itoa(size), "-c", "3", "-W", "2", host)
+	if err != nil && pingDFRejectedRe.MatchString(err.Error()) {
+		return "", fmt.Errorf("ping of node %s did not accept the don't fragment option: %v", srcPod.Spec.NodeName, err)
+	}
+	return out, err
+}
+
+// createServerDaemonSet creates a DaemonSet of netexec servers tolerating every taint, so that a
+// server runs on each node, and waits for all of its pods to be ready.
+func createServerDaemonSet(f *framework.Framework, name string, labels map[string]string) *appsv1.DaemonSet {
+	pod := newAgnhostPod(name, "", labels, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: pod.ObjectMeta,
+				Spec: v1.PodSpec{
+					Containers:  pod.Spec.Containers,
+					Tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+				},
+			},
+		},
+	}
+	ds, err := f.ClientSet.AppsV1().DaemonSets(f.Namespace.Name).Create(ds)
+	framework.ExpectNoError(err, "failed to create DaemonSet %s", name)
+
+	err = wait.PollImmediate(pokeInterval, framework.PodStartTimeout, func() (bool, error) {
+		ds, err = f.ClientSet.AppsV1().DaemonSets(f.Namespace.Name).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return ds.Status.DesiredNumberScheduled > 0 && ds.Status.NumberReady == ds.Status.DesiredNumberScheduled, nil
+	})
+	framework.ExpectNoError(err, "DaemonSet %s did not become ready", name)
+	return ds
+}
+
+// name of the management port ovnkube-node plugs into the host network of every node
+const mgmtPortName = "ovn-k8s-mp0"
+
+// checkMTUPaths reads the MTU of the management port of the node and of the server pod, then
+// verifies that the node reaches the server with don't fragment packets filling the management
+// port MTU and that the client pod reaches it with ones filling the pod MTU. It returns both MTUs.
+func checkMTUPaths(nodeName string, server, client *v1.Pod) (int, int, error) {
+	// IPv4 and ICMP headers added to the ping payload
+	const icmpHeaders = 28
+	mgmtMTU, err := getNodeMTU(nodeName, mgmtPortName)
+	if err != nil {
+		return 0, 0, err
+	}
+	out, err := framework.RunKubectl("exec", server.Name, "--namespace="+server.Namespace, "--", "cat", "/sys/class/net/eth0/mtu")
+	if err != nil {
+		return 0, 0, err
+	}
+	podMTU, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, 0, err
+	}
+	serverIP := server.Status.PodIP
+	if out, err := runCommand("docker", "exec", nodeName, "ping", "-M", "do", "-s", strconv.Itoa(mgmtMTU-icmpHeaders), "-c", "3", "-W", "2", serverIP); err != nil {
+		return mgmtMTU, podMTU, fmt.Errorf("node %s did not reach %s at the management port MTU %d: %v %s", nodeName, serverIP, mgmtMTU, err, out)
+	}
+	if out, err := pingWithDF(client, serverIP, podMTU-icmpHeaders); err != nil {
+		return mgmtMTU, podMTU, fmt.Errorf("pod %s did not reach %s at the pod MTU %d: %v %s", client.Name, serverIP, podMTU, err, out)
+	}
+	return mgmtMTU, podMTU, nil
+}
+
+// churnClusterRoutes adds count static routes for otherwise unused /24 subnets to the cluster
+// router in a single transaction and deletes them again in another one, rounds times.
+func churnClusterRoutes(f *framework.Framework, count, rounds int, nexthop string) error {
+	for round := 0; round < rounds; round++ {
+		var add, del []string
+		for i := 0; i < count; i++ {
+			// subnets of the 198.18.0.0/15 benchmarking range
+			prefix := fmt.Sprintf("198.%d.%d.0/24", 18+i/256, i%256)
+			add = append(add, "--", "--may-exist", "lr-route-add", "ovn_cluster_router", prefix, nexthop)
+			del = append(del, "--", "--if-exists", "lr-route-del", "ovn_cluster_router", prefix)
+		}
+		if out, err := runNbctl(f, add...); err != nil {
+			return fmt.Errorf("failed to add %d routes in round %d: %v (%s)", count, round, err, out)
+		}
+		if out, err := runNbctl(f, del...); err != nil {
+			return fmt.Errorf("failed to delete %d routes in round %d: %v (%s)", count, round, err, out)
+		}
+	}
+	return nil
+}
+
+// addNodeHostSubnet plugs a dummy interface holding the address into the KIND node container,
+// which routes the whole subnet of the address to the host network of the node.
+func addNodeHostSubnet(nodeName, iface, cidr string) error {
+	if _, err := runCommand("docker", "exec", nodeName, "ip", "link", "add", iface, "type", "dummy"); err != nil {
+		return err
+	}
+	if _, err := runCommand("docker", "exec", nodeName, "ip", "addr", "add", cidr, "dev", iface); err != nil {
+		return err
+	}
+	_, err := runCommand("docker", "exec", nodeName, "ip", "link", "set", iface, "up")
+	return err
+}
+
+// getNodeRouteDevice returns the device the host network of the KIND node routes the IP through
+func getNodeRouteDevice(nodeName, ip string) (string, error) {
+	out, err := runCommand("docker", "exec", nodeName, "ip", "route", "get", ip)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "dev" {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no device in the route to %s on node %s: %q", ip, nodeName, out)
+}
+
+// getPodSysctl reads the value of the sysctl as seen from inside the pod, with the whitespace the
+// kernel separates multiple values with normalized to single spaces
+func getPodSysctl(namespace, podName, name string) (string, error) {
+	out, err := framework.RunKubectl("exec", podName, "--namespace="+namespace, "--",
+		"cat", "/proc/sys/"+strings.Replace(name, ".", "/", -1))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(out), " "), nil
+}
+
+// Validate pod to pod connectivity across the whole cluster
+var _ = Describe("e2e cluster connectivity", func() {
+	const (
+		svcname string = "cluster-connectivity"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should provide full mesh connectivity between the pods of a DaemonSet", func() {
+		dsLabels := map[string]string{"app": "mesh-server"}
+		createServerDaemonSet(f, "mesh-server", dsLabels)
+
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(dsLabels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the DaemonSet pods")
+
+		// the DaemonSet pods act both as the clients and the targets of the mesh
+		clients := make(map[string]string)
+		targets := make(map[string]string)
+		for _, pod := range podList.Items {
+			clients[pod.Spec.NodeName] = pod.Name
+			targets[pod.Spec.NodeName] = pod.Status.PodIP
+		}
+		if len(targets) < 2 {
+			framework.Skipf("Test requires DaemonSet pods on at least 2 nodes, found %d", len(targets))
+		}
+
+		By(fmt.Sprintf("Probing every DaemonSet pod from the DaemonSet pods on the other %d nodes", len(targets)-1))
+		framework.ExpectNoError(runMeshConnectivity(f.Namespace.Name, clients, targets, netexecPort))
+	})
+
+	It("Should keep pods on nodes with different MTUs connected at the smaller effective MTU", func() {
+		const (
+			uplink string = "eth0"
+			// IPv4 and ICMP headers added to the ping payload
+			icmpHeaders = 28
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		mtuConfig, err := getOvnConfig(f, "mtu")
+		framework.ExpectNoError(err, "failed to get the MTU of the cluster")
+		podMTU, err := strconv.Atoi(mtuConfig)
+		framework.ExpectNoError(err, "invalid cluster MTU %q", mtuConfig)
+
+		// shrink the uplink of the server node to the smallest MTU still carrying full sized
+		// encapsulated pod packets, leaving the uplink of the client node untouched
+		originalMTU, err := getNodeMTU(serverNode, uplink)
+		framework.ExpectNoError(err, "failed to get the MTU of %s on node %s", uplink, serverNode)
+		smallerMTU := podMTU + geneveOverhead
+		if smallerMTU >= originalMTU {
+			framework.Skipf("Uplink MTU %d of node %s leaves no room below it for the cluster MTU %d", originalMTU, serverNode, podMTU)
+		}
+		By(fmt.Sprintf("Setting the MTU of %s on node %s to %d", uplink, serverNode, smallerMTU))
+		defer setNodeMTU(serverNode, uplink, originalMTU)
+		framework.ExpectNoError(setNodeMTU(serverNode, uplink, smallerMTU))
+
+		server := createServerPod(f, f.Namespace.Name, "mtu-server", serverNode, nil)
+		client := createClientPod(f, f.Namespace.Name, "mtu-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "mtu-client", server.Status.PodIP, netexecPort)
+
+		By(fmt.Sprintf("Verifying don't fragment packets filling the pod MTU of %d cross the nodes", podMTU))
+		out, err := pingWithDF(client, server.Status.PodIP, podMTU-icmpHeaders)
+		framework.ExpectNoError(err, "full sized packets from %s to %s were lost: %s", clientNode, serverNode, out)
+
+		By("Verifying larger don't fragment packets are refused")
+		out, err = pingWithDF(client, server.Status.PodIP, podMTU-icmpHeaders+1)
+		if err == nil {
+			framework.Failf("Expected packets larger than the pod MTU %d not to be sent, got: %s", podMTU, out)
+		}
+		if !pingDFRefusedRe.MatchString(err.Error()) {
+			framework.Failf("Expected packets larger than the pod MTU %d to be refused for their size, got: %v", podMTU, err)
+		}
+	})
+
+	It("Should keep pods connected while the cluster router goes through a mass route update", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+		server := createServerPod(f, f.Namespace.Name, "route-churn-server", serverNode, nil)
+
+		// routes need a nexthop on a network of the router for northd to translate them
+		mgmtPort, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=addresses", "find", "logical_switch_port", "name=k8s-"+serverNode)
+		framework.ExpectNoError(err, "failed to get the management port of node %s", serverNode)
+		fields := strings.Fields(mgmtPort)
+		if len(fields) < 2 {
+			framework.Failf("Management port of node %s has no IP address: %q", serverNode, mgmtPort)
+		}
+		nexthop := fields[1]
+
+		By("Probing the server continuously while the cluster router routes churn")
+		podChan, errChan := make(chan *v1.Pod), make(chan error)
+		go checkContinuousConnectivity(f, clientNode, "route-churn-client", server.Status.PodIP, netexecPort, 2, 10, 2, podChan, errChan)
+		receiveContinuousConnectivityPod(podChan, errChan)
+		framework.ExpectNoError(churnClusterRoutes(f, 500, 5, nexthop))
+		framework.ExpectNoError(<-errChan, "connectivity to %s was interrupted by the route churn", server.Status.PodIP)
+	})
+
+	It("Should keep pod to pod traffic in the overlay when a node host network overlaps the pod subnet of another node", func() {
+		const (
+			serverName string = "overlap-server"
+			clientName string = "overlap-client"
+			hostIface  string = "ovn-overlap"
+		)
+		// ovn-kubernetes only checks the cluster, service and join subnets against each other when
+		// it starts and never inspects the addresses of the nodes, so it logs no warning about the
+		// overlap and the test only covers the datapath
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, got %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		server := createServerPod(f, f.Namespace.Name, serverName, serverNode, nil)
+		subnet, err := getNodeSwitchSubnet(f, serverNode)
+		framework.ExpectNoError(err, "failed to get the subnet of node %s", serverNode)
+		// the last address before the broadcast one is the least likely to be handed to a pod
+		hostIP, err := utilnet.GetIndexedIP(subnet, int(utilnet.RangeSize(subnet)-2))
+		framework.ExpectNoError(err)
+		prefixLen, _ := subnet.Mask.Size()
+		hostCIDR := fmt.Sprintf("%s/%d", hostIP, prefixLen)
+
+		By(fmt.Sprintf("Adding the host subnet %s overlapping the pod subnet of node %s to node %s", hostCIDR, serverNode, clientNode))
+		defer func() {
+			if _, err := runCommand("docker", "exec", clientNode, "ip", "link", "del", hostIface); err != nil {
+				framework.Logf("Failed to delete %s from node %s: %v", hostIface, clientNode, err)
+			}
+		}()
+		err = addNodeHostSubnet(clientNode, hostIface, hostCIDR)
+		framework.ExpectNoError(err, "failed to add the host subnet %s to node %s", hostCIDR, clientNode)
+		dev, err := getNodeRouteDevice(clientNode, server.Status.PodIP)
+		framework.ExpectNoError(err, "failed to get the route to %s on node %s", server.Status.PodIP, clientNode)
+		if dev != hostIface {
+			framework.Failf("Node %s routes %s through %s instead of the overlapping %s", clientNode, server.Status.PodIP, dev, hostIface)
+		}
+
+		By(fmt.Sprintf("Verifying a pod on node %s still reaches the server pod %s through the overlay", clientNode, server.Status.PodIP))
+		createClientPod(f, f.Namespace.Name, clientName, clientNode, nil)
+		var answer string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			answer, err = pokeHTTP(f.Namespace.Name, clientName, server.Status.PodIP, netexecPort)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "pod %s did not reach %s", clientName, server.Status.PodIP)
+		if strings.TrimSpace(answer) != serverName {
+			framework.Failf("Request of pod %s to %s was answered by %q instead of the server pod %s", clientName, server.Status.PodIP, answer, serverName)
+		}
+	})
+
+	It("Should reach pods from their node over a management port with a smaller MTU than the pods", func() {
+		const (
+			serverName string = "mgmt-mtu-server"
+			clientName string = "mgmt-mtu-client"
+			// how much smaller than the pod MTU the management port is made
+			mtuShrink = 200
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+
+		// the kubelet probes the readiness of the server through the management port
+		server := newAgnhostPod(serverName, nodeName, nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		server.Spec.Containers[0].ReadinessProbe = &v1.Probe{
+			Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{Path: "/hostname", Port: intstr.FromInt(netexecPort)},
+			},
+			PeriodSeconds: 2,
+		}
+		server = f.PodClient().CreateSync(server)
+		client := createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		mgmtMTU, podMTU, err := checkMTUPaths(nodeName, server, client)
+		framework.ExpectNoError(err)
+
+		smallerMTU := podMTU - mtuShrink
+		By(fmt.Sprintf("Setting the MTU of the management port of node %s to %d, below the pod MTU %d", nodeName, smallerMTU, podMTU))
+		defer setNodeMTU(nodeName, mgmtPortName, mgmtMTU)
+		framework.ExpectNoError(setNodeMTU(nodeName, mgmtPortName, smallerMTU))
+
+		By("Verifying both paths carry full sized packets at their own MTU")
+		gotMgmtMTU, gotPodMTU, err := checkMTUPaths(nodeName, server, client)
+		framework.ExpectNoError(err)
+		if gotMgmtMTU != smallerMTU || gotPodMTU != podMTU {
+			framework.Failf("Expected management port MTU %d and pod MTU %d, got %d and %d", smallerMTU, podMTU, gotMgmtMTU, gotPodMTU)
+		}
+
+		By(fmt.Sprintf("Verifying the kubelet keeps probing pod %s successfully", serverName))
+		time.Sleep(10 * time.Second)
+		pod, err := f.PodClient().Get(serverName, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", serverName)
+		for _, status := range pod.Status.ContainerStatuses {
+			if !status.Ready {
+				framework.Failf("Container %s of pod %s is no longer ready", status.Name, serverName)
+			}
+		}
+	})
+
+	It("Should keep pods connected when they set a networking sysctl", func() {
+		const (
+			serverName string = "sysctl-server"
+			clientName string = "sysctl-client"
+			sysctlName string = "net.ipv4.ip_local_port_range"
+			firstPort         = 40000
+			lastPort          = 40100
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		server := f.PodClient().CreateSync(newAgnhostPod(serverName, nodes.Items[0].Name, nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)))
+
+		By(fmt.Sprintf("Creating pod %s setting %s to %d-%d", clientName, sysctlName, firstPort, lastPort))
+		client := newAgnhostPod(clientName, nodes.Items[1].Name, nil, "pause")
+		client.Spec.SecurityContext = &v1.PodSecurityContext{
+			Sysctls: []v1.Sysctl{{Name: sysctlName, Value: fmt.Sprintf("%d %d", firstPort, lastPort)}},
+		}
+		f.PodClient().CreateSync(client)
+		value, err := getPodSysctl(f.Namespace.Name, clientName, sysctlName)
+		framework.ExpectNoError(err, "failed to read %s in pod %s", sysctlName, clientName)
+		if expected := fmt.Sprintf("%d %d", firstPort, lastPort); value != expected {
+			framework.Failf("Expected %s to be %q in pod %s, got %q", sysctlName, expected, clientName, value)
+		}
+
+		By(fmt.Sprintf("Verifying pod %s reaches pod %s from a source port within the range", clientName, serverName))
+		url := fmt.Sprintf("http://%s/clientip", net.JoinHostPort(server.Status.PodIP, strconv.Itoa(netexecPort)))
+		var answer string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			answer, err = framework.RunKubectl("exec", clientName, "--namespace="+f.Namespace.Name, "--",
+				"curl", "--connect-timeout", "2", "--max-time", "5", "-s", "-f", url)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "pod %s did not reach %s", clientName, server.Status.PodIP)
+		_, port, err := net.SplitHostPort(strings.TrimSpace(answer))
+		framework.ExpectNoError(err, "failed to parse the client address %q", answer)
+		if p, err := strconv.Atoi(port); err != nil || p < firstPort || p > lastPort {
+			framework.Failf("Pod %s connected from port %s, outside of the %s range %d-%d", clientName, port, sysctlName, firstPort, lastPort)
+		}
+	})
+})
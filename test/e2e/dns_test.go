@@ -0,0 +1,219 @@
+package e2e_test
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2esset "k8s.io/kubernetes/test/e2e/framework/statefulset"
+)
+
+// resolveName looks the name up from inside the client pod and returns the first address it
+// resolves to.
+func resolveName(namespace, clientPodName, name string) (string, error) {
+	kubectlOut, err := framework.RunKubectl("exec", clientPodName, "--namespace="+namespace, "--", "dig", "+short", "+search", name)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(kubectlOut, "\n") {
+		// skip any CNAME answer preceding the addresses
+		if line = strings.TrimSpace(line); line != "" && !strings.HasSuffix(line, ".") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("name %s did not resolve in pod %s/%s", name, namespace, clientPodName)
+}
+
+// resolveCNAME looks the name up from inside the client pod and returns the canonical name it
+// is an alias of, without the trailing dot.
+func resolveCNAME(namespace, clientPodName, name string) (string, error) {
+	kubectlOut, err := framework.RunKubectl("exec", clientPodName, "--namespace="+namespace, "--", "dig", "+short", "+search", name, "CNAME")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(kubectlOut, "\n") {
+		if line = strings.TrimSpace(line); strings.HasSuffix(line, ".") {
+			return strings.TrimSuffix(line, "."), nil
+		}
+	}
+	return "", fmt.Errorf("name %s is not an alias in pod %s/%s", name, namespace, clientPodName)
+}
+
+// statefulSetPodDNSName returns the stable DNS name of the StatefulSet pod at the given ordinal
+func statefulSetPodDNSName(namespace, setName, serviceName string, ordinal int) string {
+	return fmt.Sprintf("%s-%d.%s.%s.svc.cluster.local", setName, ordinal, serviceName, namespace)
+}
+
+// setCustomDNS makes the pod bypass the cluster DNS configuration and resolve names with the
+// given nameserver and search domains only.
+func setCustomDNS(pod *v1.Pod, nameserver string, searches []string) {
+	pod.Spec.DNSPolicy = v1.DNSNone
+	pod.Spec.DNSConfig = &v1.PodDNSConfig{
+		Nameservers: []string{nameserver},
+		Searches:    searches,
+	}
+}
+
+// Validate name resolution and reachability of pods through cluster DNS
+var _ = Describe("e2e DNS connectivity", func() {
+	const (
+		svcname    string = "dns-connectivity"
+		clientName string = "dns-client"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should keep the DNS name of a StatefulSet pod reachable after the pod is rescheduled", func() {
+		const (
+			setName     string = "sts-web"
+			serviceName string = "sts-web-svc"
+		)
+		setLabels := map[string]string{"app": setName}
+
+		_, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(e2esset.CreateStatefulSetService(serviceName, setLabels))
+		framework.ExpectNoError(err, "failed to create the headless service %s", serviceName)
+
+		ss := e2esset.NewStatefulSet(setName, f.Namespace.Name, serviceName, 1, nil, nil, setLabels)
+		ss.Spec.Template.Spec.Containers = newAgnhostPod(setName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)).Spec.Containers
+		ss, err = f.ClientSet.AppsV1().StatefulSets(f.Namespace.Name).Create(ss)
+		framework.ExpectNoError(err, "failed to create StatefulSet %s", setName)
+		e2esset.WaitForRunningAndReady(f.ClientSet, 1, ss)
+
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		podDNSName := statefulSetPodDNSName(f.Namespace.Name, setName, serviceName, 0)
+
+		// verifyPodDNS waits for the stable name to resolve to the current IP of the pod and to be reachable
+		verifyPodDNS := func() {
+			pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(setName+"-0", metav1.GetOptions{})
+			framework.ExpectNoError(err, "failed to get the StatefulSet pod")
+			var resolved string
+			err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+				resolved, _ = resolveName(f.Namespace.Name, clientName, podDNSName)
+				return resolved == pod.Status.PodIP, nil
+			})
+			framework.ExpectNoError(err, "%s resolved to %q instead of the pod IP %s", podDNSName, resolved, pod.Status.PodIP)
+			expectConnectivity(f.Namespace.Name, clientName, podDNSName, netexecPort)
+		}
+
+		By(fmt.Sprintf("Verifying %s resolves to the StatefulSet pod and is reachable", podDNSName))
+		verifyPodDNS()
+
+		By("Deleting the StatefulSet pod so that it gets rescheduled")
+		oldPod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(setName+"-0", metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get the StatefulSet pod")
+		e2esset.DeleteStatefulPodAtIndex(f.ClientSet, 0, ss)
+		err = wait.PollImmediate(pokeInterval, framework.PodStartTimeout, func() (bool, error) {
+			pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(setName+"-0", metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			return pod.UID != oldPod.UID && pod.Status.PodIP != "", nil
+		})
+		framework.ExpectNoError(err, "StatefulSet pod %s-0 was not recreated", setName)
+		e2esset.WaitForRunningAndReady(f.ClientSet, 1, ss)
+
+		By(fmt.Sprintf("Verifying %s resolves to the new pod and is reachable again", podDNSName))
+		verifyPodDNS()
+	})
+
+	It("Should resolve and reach services through a custom nameserver bypassing the cluster DNS configuration", func() {
+		const (
+			serverName string = "custom-dns-server"
+			podName    string = "custom-dns-client"
+		)
+		dnsPods, err := f.ClientSet.CoreV1().Pods("kube-system").List(metav1.ListOptions{LabelSelector: "k8s-app=kube-dns"})
+		framework.ExpectNoError(err, "failed to list the cluster DNS pods")
+		var nameserver string
+		for _, pod := range dnsPods.Items {
+			if pod.Status.Phase == v1.PodRunning && pod.Status.PodIP != "" {
+				nameserver = pod.Status.PodIP
+				break
+			}
+		}
+		if nameserver == "" {
+			framework.Skipf("Test requires a running cluster DNS pod")
+		}
+
+		serverLabels := map[string]string{"app": serverName}
+		server := createServerPod(f, f.Namespace.Name, serverName, "", serverLabels)
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: serverName,
+			},
+			Spec: v1.ServiceSpec{
+				Selector: serverLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create service %s", serverName)
+
+		By(fmt.Sprintf("Creating pod %s resolving names through the DNS pod %s directly", podName, nameserver))
+		pod := newAgnhostPod(podName, "", nil, "pause")
+		setCustomDNS(pod, nameserver, []string{f.Namespace.Name + ".svc.cluster.local"})
+		f.PodClient().CreateSync(pod)
+		resolvConf, err := framework.RunKubectl("exec", podName, "--namespace="+f.Namespace.Name, "--", "cat", "/etc/resolv.conf")
+		framework.ExpectNoError(err, "failed to read the resolv.conf of pod %s", podName)
+		if !strings.Contains(resolvConf, "nameserver "+nameserver) {
+			framework.Failf("Pod %s does not use the custom nameserver %s:\n%s", podName, nameserver, resolvConf)
+		}
+
+		By(fmt.Sprintf("Verifying service %s resolves and is reachable through the custom nameserver", serverName))
+		var resolved string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			resolved, _ = resolveName(f.Namespace.Name, podName, serverName)
+			return resolved == svc.Spec.ClusterIP, nil
+		})
+		framework.ExpectNoError(err, "%s resolved to %q instead of the service IP %s", serverName, resolved, svc.Spec.ClusterIP)
+		expectConnectivity(f.Namespace.Name, podName, serverName, netexecPort)
+		expectConnectivity(f.Namespace.Name, podName, server.Status.PodIP, netexecPort)
+	})
+
+	It("Should resolve an ExternalName service to its target and reach the target through it", func() {
+		const (
+			targetName string = "external-name-target"
+			aliasName  string = "external-name-alias"
+		)
+		targetLabels := map[string]string{"app": targetName}
+		createServerPod(f, f.Namespace.Name, targetName, "", targetLabels)
+		target, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: targetName,
+			},
+			Spec: v1.ServiceSpec{
+				Selector: targetLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create service %s", targetName)
+		targetFQDN := fmt.Sprintf("%s.%s.svc.cluster.local", targetName, f.Namespace.Name)
+		_, err = f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: aliasName,
+			},
+			Spec: v1.ServiceSpec{
+				Type:         v1.ServiceTypeExternalName,
+				ExternalName: targetFQDN,
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the ExternalName service %s", aliasName)
+
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		By(fmt.Sprintf("Verifying %s is an alias of %s resolving to %s", aliasName, targetFQDN, target.Spec.ClusterIP))
+		var alias, resolved string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			alias, _ = resolveCNAME(f.Namespace.Name, clientName, aliasName)
+			resolved, _ = resolveName(f.Namespace.Name, clientName, aliasName)
+			return alias == targetFQDN && resolved == target.Spec.ClusterIP, nil
+		})
+		framework.ExpectNoError(err, "%s is an alias of %q resolving to %q, expected %s resolving to %s",
+			aliasName, alias, resolved, targetFQDN, target.Spec.ClusterIP)
+
+		By(fmt.Sprintf("Verifying the target is reachable through %s", aliasName))
+		expectConnectivity(f.Namespace.Name, clientName, aliasName, netexecPort)
+	})
+})
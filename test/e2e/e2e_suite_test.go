@@ -3,8 +3,10 @@ package e2e_test
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"k8s.io/kubectl/pkg/generated"
 	"k8s.io/kubernetes/test/e2e/framework"
@@ -29,6 +31,10 @@ func handleFlags() {
 
 var viperConfig = flag.String("viper-config", "", "The name of a viper config file (https://github.com/spf13/viper#what-is-viper). All e2e command line parameters can also be configured in such a file. May contain a path and may or may not contain the file suffix. The default is to look for an optional file with `e2e` as base name. If a file is specified explicitly, it must be present.")
 
+var hybridOverlayVNI = flag.Int("hybrid-overlay-vni", 4097, "The VXLAN network identifier the hybrid overlay of the cluster is configured with, used by the external gateway tests.")
+
+var largeServiceBackends = flag.Int("large-service-backends", 100, "The number of backends of the service of the large endpoint set test. The default is what a KIND cluster schedules; raise it to thousands on clusters with the capacity.")
+
 // required due to go1.13 issue: https://github.com/onsi/ginkgo/issues/602
 func TestMain(m *testing.M) {
 	// Register test flags, then parse flags.
@@ -51,6 +57,9 @@ func TestMain(m *testing.M) {
 
 	framework.AfterReadingAllFlags(&framework.TestContext)
 
+	// the tests draw random names and samples, which must differ from one run to the next
+	rand.Seed(time.Now().UnixNano())
+
 	// TODO: Deprecating repo-root over time... instead just use gobindata_util.go , see #23987.
 	// Right now it is still needed, for example by
 	// test/e2e/framework/ingress/ingress_utils.go
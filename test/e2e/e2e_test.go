@@ -1,13 +1,19 @@
 package e2e_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/onsi/ginkgo"
@@ -16,21 +22,38 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/kubernetes/test/e2e/framework"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
 	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
 )
 
 const (
 	// IANA assigned VXLAN UDP port - rfc7348
 	vxlanPort = "4789"
+	// nodeSubnetsAnnotation holds the pod subnet of every network of a node
+	nodeSubnetsAnnotation = "k8s.ovn.org/node-subnets"
 )
 
-func checkContinuousConnectivity(f *framework.Framework, nodeName, podName, host string, port, timeout int, podChan chan *v1.Pod, errChan chan error) {
+// checkContinuousConnectivity runs a pod probing host:port iterations times, sends the pod on
+// podChan once it runs and then the result of the probes on errChan. If it gives up before the
+// pod runs, it closes podChan before sending the error, see receiveContinuousConnectivityPod.
+func checkContinuousConnectivity(f *framework.Framework, nodeName, podName, host string, port, timeout, iterations, intervalSeconds int, podChan chan *v1.Pod, errChan chan error) {
 	contName := fmt.Sprintf("%s-container", podName)
+	giveUp := func(err error) {
+		close(podChan)
+		errChan <- err
+	}
 
+	if iterations <= 0 || intervalSeconds < 0 || timeout <= 0 {
+		giveUp(fmt.Errorf("continuous connectivity check of pod %s needs positive iterations and timeout and a non negative interval, got %d, %d and %d",
+			podName, iterations, timeout, intervalSeconds))
+		return
+	}
 	command := []string{
 		"bash", "-c",
-		"set -xe; for i in {1..10}; do nc -vz -w " + strconv.Itoa(timeout) + " " + host + " " + strconv.Itoa(port) + "; sleep 2; done",
+		"set -xe; for i in {1.." + strconv.Itoa(iterations) + "}; do nc -vz -w " + strconv.Itoa(timeout) + " " + host + " " + strconv.Itoa(port) + "; sleep " + strconv.Itoa(intervalSeconds) + "; done",
 	}
 
 	pod := &v1.Pod{
@@ -52,19 +75,19 @@ func checkContinuousConnectivity(f *framework.Framework, nodeName, podName, host
 	podClient := f.ClientSet.CoreV1().Pods(f.Namespace.Name)
 	_, err := podClient.Create(pod)
 	if err != nil {
-		errChan <- err
+		giveUp(err)
 		return
 	}
 
 	err = e2epod.WaitForPodNotPending(f.ClientSet, f.Namespace.Name, podName)
 	if err != nil {
-		errChan <- err
+		giveUp(err)
 		return
 	}
 
 	podGet, err := podClient.Get(podName, metav1.GetOptions{})
 	if err != nil {
-		errChan <- err
+		giveUp(err)
 		return
 	}
 
@@ -84,6 +107,16 @@ func checkContinuousConnectivity(f *framework.Framework, nodeName, podName, host
 	errChan <- err
 }
 
+// receiveContinuousConnectivityPod returns the pod started by checkContinuousConnectivity, failing
+// the test with the error of the check if it gave up before starting one.
+func receiveContinuousConnectivityPod(podChan chan *v1.Pod, errChan chan error) *v1.Pod {
+	pod, ok := <-podChan
+	if !ok {
+		framework.Failf("Continuous connectivity check did not start: %v", <-errChan)
+	}
+	return pod
+}
+
 // pingCommand is the type to hold ping command.
 type pingCommand string
 
@@ -94,15 +127,91 @@ const (
 	ipv6PingCommand pingCommand = "ping6"
 )
 
-// Place the workload on the specified node to test external connectivity
-func checkConnectivityPingToHost(f *framework.Framework, nodeName, podName, host string, pingCmd pingCommand, timeout int) error {
-	contName := fmt.Sprintf("%s-container", podName)
+// pingScript returns the shell command pinging host with the given ping command
+func pingScript(pingCmd pingCommand, host string, timeout int) string {
 	// Ping options are:
 	// -c sends 3 pings
 	// -W wait at most 2 seconds for a reply
 	// -w timeout
+	return fmt.Sprintf("%s -c 3 -W 2 -w %s %s", string(pingCmd), strconv.Itoa(timeout), host)
+}
+
+// Place the workload on the specified node to test external connectivity
+func checkConnectivityPingToHost(f *framework.Framework, nodeName, podName, host string, pingCmd pingCommand, timeout int) error {
+	return runPingPod(f, nodeName, podName, pingScript(pingCmd, host, timeout))
+}
+
+// checkConnectivityPingToHostAuto works like checkConnectivityPingToHost but picks ping or ping6
+// from the address family of host. A host name may resolve to either family, so both are tried
+// and either one succeeding will do.
+func checkConnectivityPingToHostAuto(f *framework.Framework, nodeName, podName, host string, timeout int) error {
+	if ip := net.ParseIP(host); ip != nil {
+		pingCmd := ipv4PingCommand
+		if ip.To4() == nil {
+			pingCmd = ipv6PingCommand
+		}
+		return checkConnectivityPingToHost(f, nodeName, podName, host, pingCmd, timeout)
+	}
+	return runPingPod(f, nodeName, podName,
+		fmt.Sprintf("%s || %s", pingScript(ipv4PingCommand, host, timeout), pingScript(ipv6PingCommand, host, timeout)))
+}
+
+// checkConnectivityTCPToHost places the workload on the specified node and verifies a TCP
+// connection to host:port can be established
+func checkConnectivityTCPToHost(f *framework.Framework, nodeName, podName, host string, port, timeout int) error {
+	// nc options are:
+	// -v report the connection status in the pod logs
+	// -z only open the connection without sending data
+	// -w timeout
+	return runPingPod(f, nodeName, podName,
+		fmt.Sprintf("nc -vz -w %d %s %d", timeout, host, port))
+}
+
+// checkConnectivityUDPToHost places the workload on the specified node and verifies the UDP
+// server at host:port answers. UDP has no handshake to tell an open port from a dropped datagram,
+// so the server is expected to be agnhost netexec, which replies to the hostname command.
+func checkConnectivityUDPToHost(f *framework.Framework, nodeName, podName, host string, port, timeout int) error {
+	return runPingPod(f, nodeName, podName,
+		fmt.Sprintf("echo hostname | nc -u -v -w %d %s %d | grep -q .", timeout, host, port))
+}
+
+var (
+	// trackedPodsLock protects trackedPods
+	trackedPodsLock sync.Mutex
+	// trackedPods holds the names of the pods created by the connectivity helpers, by namespace
+	trackedPods = make(map[string][]string)
+)
+
+// trackTestPod records a pod created by a connectivity helper so deleteTrackedPods removes it
+func trackTestPod(namespace, podName string) {
+	trackedPodsLock.Lock()
+	defer trackedPodsLock.Unlock()
+	trackedPods[namespace] = append(trackedPods[namespace], podName)
+}
+
+// deleteTrackedPods deletes the pods the connectivity helpers created in the namespace of the
+// framework right away, rather than leaving them to the namespace teardown.
+func deleteTrackedPods(f *framework.Framework) {
+	trackedPodsLock.Lock()
+	podNames := trackedPods[f.Namespace.Name]
+	delete(trackedPods, f.Namespace.Name)
+	trackedPodsLock.Unlock()
+
+	zero := int64(0)
+	for _, podName := range podNames {
+		err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Delete(podName, &metav1.DeleteOptions{GracePeriodSeconds: &zero})
+		if err != nil && !apierrors.IsNotFound(err) {
+			framework.Logf("Warning: Failed to delete pod %s/%s: %v", f.Namespace.Name, podName, err)
+		}
+	}
+}
+
+// runPingPod runs the ping script in a pod on the specified node once the pod had time to get
+// its network set up, and waits for it to succeed.
+func runPingPod(f *framework.Framework, nodeName, podName, script string) error {
+	contName := fmt.Sprintf("%s-container", podName)
 	command := []string{"/bin/sh", "-c"}
-	args := []string{fmt.Sprintf("sleep 20; %s -c 3 -W 2 -w %s %s", string(pingCmd), strconv.Itoa(timeout), host)}
+	args := []string{"sleep 20; " + script}
 
 	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -126,6 +235,7 @@ func checkConnectivityPingToHost(f *framework.Framework, nodeName, podName, host
 	if err != nil {
 		return err
 	}
+	trackTestPod(f.Namespace.Name, podName)
 	err = e2epod.WaitForPodSuccessInNamespace(f.ClientSet, podName, f.Namespace.Name)
 
 	if err != nil {
@@ -164,6 +274,8 @@ func createGenericPod(f *framework.Framework, podName, nodeSelector string, comm
 	_, err := podClient.Create(pod)
 	if err != nil {
 		framework.Logf("Warning: Failed to get logs from pod %q: %v", pod.Name, err)
+	} else {
+		trackTestPod(f.Namespace.Name, podName)
 	}
 	err = e2epod.WaitForPodNotPending(f.ClientSet, podName, f.Namespace.Name)
 	if err != nil {
@@ -186,15 +298,404 @@ func getPodAddress(podName, namespace string) (string, error) {
 	return podIP, nil
 }
 
+// getNodeSubnet returns the pod subnet of the network from the node-subnets annotation of the node
+func getNodeSubnet(f *framework.Framework, nodeName, network string) (string, error) {
+	node, err := f.ClientSet.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	annotation, ok := node.Annotations[nodeSubnetsAnnotation]
+	if !ok {
+		return "", fmt.Errorf("node %s has no %s annotation", nodeName, nodeSubnetsAnnotation)
+	}
+	subnets := make(map[string]string)
+	if err := json.Unmarshal([]byte(annotation), &subnets); err != nil {
+		return "", fmt.Errorf("invalid %s annotation %q of node %s: %v", nodeSubnetsAnnotation, annotation, nodeName, err)
+	}
+	subnet, ok := subnets[network]
+	if !ok {
+		return "", fmt.Errorf("the %s annotation of node %s has no subnet for network %q: %q", nodeSubnetsAnnotation, nodeName, network, annotation)
+	}
+	return subnet, nil
+}
+
+// getPodAddressWithRetry polls for the IP of a pod that is no longer pending, as the address may
+// be reported a bit later than the pod status.
+func getPodAddressWithRetry(podName, namespace string, retries int) (string, error) {
+	for i := 1; i < retries; i++ {
+		podIP, err := getPodAddress(podName, namespace)
+		if err != nil {
+			framework.Logf("Warning unable to query the test pod %s %v", podName, err)
+		}
+		if net.ParseIP(podIP) != nil {
+			return podIP, nil
+		}
+		time.Sleep(time.Second * 3)
+		framework.Logf("Retry attempt %d to get pod IP from initializing pod %s", i, podName)
+	}
+	return "", fmt.Errorf("failed to get an IP for pod %s", podName)
+}
+
+// getWorkerNodes returns the names of the nodes without the master node-role label
+func getWorkerNodes(f *framework.Framework) ([]string, error) {
+	nodes, err := f.ClientSet.CoreV1().Nodes().List(metav1.ListOptions{LabelSelector: "!node-role.kubernetes.io/master"})
+	if err != nil {
+		return nil, err
+	}
+	var workers []string
+	for _, node := range nodes.Items {
+		workers = append(workers, node.Name)
+	}
+	return workers, nil
+}
+
+// clusterMode describes the layout of the KIND cluster the tests run on
+type clusterMode struct {
+	// HA is set when the cluster runs several control-plane nodes
+	HA bool
+	// SrcNode and DstNode are two distinct nodes to run the source and destination pods of tests on
+	SrcNode string
+	DstNode string
+}
+
+// detectClusterMode tells an HA cluster from a non-HA one by its control-plane nodes, and picks
+// the source and destination nodes of the tests among the worker nodes, or among the control-plane
+// nodes but the first one when there are not enough workers, as in the HA layout.
+func detectClusterMode(f *framework.Framework) (clusterMode, error) {
+	nodes, err := f.ClientSet.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return clusterMode{}, err
+	}
+	var masters, workers []string
+	for _, node := range nodes.Items {
+		if _, ok := node.Labels["node-role.kubernetes.io/master"]; ok {
+			masters = append(masters, node.Name)
+		} else {
+			workers = append(workers, node.Name)
+		}
+	}
+	sort.Strings(masters)
+	sort.Strings(workers)
+	mode := clusterMode{HA: len(masters) > 1}
+	candidates := workers
+	if len(candidates) < 2 && mode.HA {
+		candidates = masters[1:]
+	}
+	// leave out the nodes OVN does not network, such as the nodes of the hybrid overlay
+	var networked []string
+	for _, nodeName := range candidates {
+		_, err := getOvnNodePodOnNode(f, nodeName)
+		if errors.Is(err, errNoPodOnNode) {
+			continue
+		} else if err != nil {
+			return mode, err
+		}
+		networked = append(networked, nodeName)
+	}
+	candidates = networked
+	if len(candidates) < 2 {
+		return mode, fmt.Errorf("no two nodes to run the test pods on among the control-plane nodes %v and the workers %v", masters, workers)
+	}
+	mode.SrcNode, mode.DstNode = candidates[0], candidates[1]
+	return mode, nil
+}
+
+// checkConnectivityFanOut places a destination pod on every destination node, then pings all of
+// them in parallel from pods on the source node, and returns an error listing every node whose
+// pod could not be reached.
+func checkConnectivityFanOut(f *framework.Framework, srcNode string, dstNodes []string, getPodIPRetry int) error {
+	command := []string{"bash", "-c", "sleep 20000"}
+	targets := make(map[string]string, len(dstNodes))
+	var failures []string
+	for i, dstNode := range dstNodes {
+		dstPodName := fmt.Sprintf("e2e-dst-ping-pod-%d", i)
+		createGenericPod(f, dstPodName, dstNode, command)
+		podIP, err := getPodAddressWithRetry(dstPodName, f.Namespace.Name, getPodIPRetry)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", dstNode, err))
+			continue
+		}
+		targets[dstNode] = podIP
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	i := 0
+	for dstNode, podIP := range targets {
+		wg.Add(1)
+		go func(srcPodName, dstNode, podIP string) {
+			defer GinkgoRecover()
+			defer wg.Done()
+			if err := checkConnectivityPingToHostAuto(f, srcNode, srcPodName, podIP, 30); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				failures = append(failures, fmt.Sprintf("%s (%s): %v", dstNode, podIP, err))
+			}
+		}(fmt.Sprintf("e2e-src-ping-pod-%d", i), dstNode, podIP)
+		i++
+	}
+	wg.Wait()
+	if len(failures) > 0 {
+		return fmt.Errorf("pods on node %s did not reach the pods of %d nodes:\n%s", srcNode, len(failures), strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// commandTimeout bounds how long runCommand waits for the cmd to complete
+const commandTimeout = 60 * time.Second
+
 // runCommand runs the cmd and returns the combined stdout and stderr
 func runCommand(cmd ...string) (string, error) {
-	output, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	return runCommandWithContext(ctx, cmd...)
+}
+
+// runCommandWithContext runs the cmd and returns the combined stdout and stderr, killing the cmd
+// when the context is done
+func runCommandWithContext(ctx context.Context, cmd ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, cmd[0], cmd[1:]...).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("command timed out: %q: %v (%s)", strings.Join(cmd, " "), ctx.Err(), output)
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command interrupted: %q: %v (%s)", strings.Join(cmd, " "), ctx.Err(), output)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to run %q: %s (%s)", strings.Join(cmd, " "), err, output)
 	}
 	return string(output), nil
 }
 
+// runKubectlWithContext runs kubectl with the args and returns its stdout, killing kubectl when
+// the context is done
+func runKubectlWithContext(ctx context.Context, args ...string) (string, error) {
+	timeout := make(chan time.Time)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(timeout)
+		case <-done:
+		}
+	}()
+	output, err := framework.NewKubectlCommand(args...).WithTimeout(timeout).Exec()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("command timed out: %q: %v", "kubectl "+strings.Join(args, " "), ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command interrupted: %q: %v", "kubectl "+strings.Join(args, " "), ctx.Err())
+	}
+	return output, err
+}
+
+// flowPacketsRe extracts the packet counter of a flow dumped by ovs-ofctl
+var flowPacketsRe = regexp.MustCompile(`\bn_packets=(\d+)`)
+
+// assertFlowPacketCount dumps the flows of the bridge from the ovnkube-node pod of the node, or of
+// every node when nodeName is empty, and verifies every flow containing match counted exactly
+// expected packets. Each bridge must hold at least one flow containing match.
+func assertFlowPacketCount(f *framework.Framework, nodeName, bridge, match string, expected int) error {
+	options := metav1.ListOptions{LabelSelector: "name=ovnkube-node"}
+	if nodeName != "" {
+		options.FieldSelector = "spec.nodeName=" + nodeName
+	}
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(options)
+	if err != nil {
+		return err
+	}
+	if len(podList.Items) == 0 {
+		return fmt.Errorf("no ovnkube-node pod found for node %q", nodeName)
+	}
+	for _, pod := range podList.Items {
+		kubectlOut, err := framework.RunKubectl("exec", pod.Name, "--namespace="+ovnNamespace, "--container=ovnkube-node", "--",
+			"ovs-ofctl", "dump-flows", bridge)
+		if err != nil {
+			return fmt.Errorf("failed to dump the flows of %s on node %s: %v", bridge, pod.Spec.NodeName, err)
+		}
+		matched := 0
+		for _, flow := range strings.Split(kubectlOut, "\n") {
+			if !strings.Contains(flow, match) {
+				continue
+			}
+			matched++
+			counter := flowPacketsRe.FindStringSubmatch(flow)
+			if counter == nil {
+				return fmt.Errorf("flow %q of %s on node %s has no packet counter", strings.TrimSpace(flow), bridge, pod.Spec.NodeName)
+			}
+			packets, err := strconv.Atoi(counter[1])
+			if err != nil {
+				return fmt.Errorf("invalid packet counter of flow %q of %s on node %s: %v", strings.TrimSpace(flow), bridge, pod.Spec.NodeName, err)
+			}
+			if packets != expected {
+				return fmt.Errorf("flow %q of %s on node %s counted %d packets, expected %d",
+					strings.TrimSpace(flow), bridge, pod.Spec.NodeName, packets, expected)
+			}
+		}
+		if matched == 0 {
+			return fmt.Errorf("no flow of %s on node %s contains %q", bridge, pod.Spec.NodeName, match)
+		}
+	}
+	return nil
+}
+
+// errNoUnifiedCgroup is returned by getContainerCgroup when the node runs on cgroup v1, where
+// iptables cannot match the packets of a container by its cgroup path
+var errNoUnifiedCgroup = errors.New("the node does not use the unified cgroup hierarchy")
+
+// getContainerCgroup returns the cgroup v2 path of the named container of the pod, as seen from
+// its KIND node container.
+func getContainerCgroup(pod *v1.Pod, containerName string) (string, error) {
+	pid, err := getContainerPid(pod, containerName)
+	if err != nil {
+		return "", err
+	}
+	out, err := runCommand("docker", "exec", pod.Spec.NodeName, "cat", "/proc/"+pid+"/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", fmt.Errorf("container %s of pod %s: %w", containerName, pod.Name, errNoUnifiedCgroup)
+}
+
+// setMasterAPIServerBlocked adds or removes an iptables rule in the KIND node container of every
+// ovnkube-master pod dropping the connections of its ovnkube-master container to the API server
+// port ovn-kubernetes was deployed with. The rule matches the cgroup of the container, so the
+// kubelet and the control plane components of the node keep reaching the API server.
+func setMasterAPIServerBlocked(f *framework.Framework, blocked bool) error {
+	apiServer, err := getOvnConfig(f, "k8s_apiserver")
+	if err != nil {
+		return err
+	}
+	apiURL, err := url.Parse(apiServer)
+	if err != nil || apiURL.Port() == "" {
+		return fmt.Errorf("no port in the API server address %q: %v", apiServer, err)
+	}
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-master"})
+	if err != nil {
+		return err
+	}
+	if len(podList.Items) == 0 {
+		return fmt.Errorf("no ovnkube-master pod found in namespace %s", ovnNamespace)
+	}
+	action := "-D"
+	if blocked {
+		action = "-I"
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		cgroup, err := getContainerCgroup(pod, "ovnkube-master")
+		if err != nil {
+			return err
+		}
+		if _, err := runCommand("docker", "exec", pod.Spec.NodeName, "iptables", action, "OUTPUT",
+			"-m", "cgroup", "--path", cgroup, "-p", "tcp", "--dport", apiURL.Port(), "-j", "DROP"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForOvnPodRecreated waits for a pod of ovn-kubernetes whose name starts with namePrefix to be
+// Ready on the node again after the previous one was deleted. Pods being terminated do not count.
+// On timeout the error lists the pods with the prefix observed on the node by the last poll.
+func waitForOvnPodRecreated(f *framework.Framework, namePrefix, nodeName string, timeout time.Duration) error {
+	var observed []string
+	err := wait.PollImmediate(pokeInterval, timeout, func() (bool, error) {
+		podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{
+			FieldSelector: "spec.nodeName=" + nodeName,
+		})
+		if err != nil {
+			framework.Logf("Failed to list the pods of node %s: %v", nodeName, err)
+			return false, nil
+		}
+		observed = nil
+		for _, pod := range podList.Items {
+			if !strings.HasPrefix(pod.Name, namePrefix) {
+				continue
+			}
+			ready := false
+			for _, cond := range pod.Status.Conditions {
+				if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
+					ready = true
+				}
+			}
+			if ready && pod.DeletionTimestamp == nil {
+				return true, nil
+			}
+			observed = append(observed, fmt.Sprintf("%s (phase %s, ready %t, terminating %t)",
+				pod.Name, pod.Status.Phase, ready, pod.DeletionTimestamp != nil))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("no %s pod became Ready on node %s within %v, observed pods: [%s]",
+			namePrefix, nodeName, timeout, strings.Join(observed, ", "))
+	}
+	return nil
+}
+
+// killMasterAndNodePods deletes an ovnkube-master pod and the ovnkube-node pod of the node at
+// the same time, waits for both to be replaced by running pods and returns how long it took.
+func killMasterAndNodePods(f *framework.Framework, nodeName string) (time.Duration, error) {
+	podClient := f.ClientSet.CoreV1().Pods(ovnNamespace)
+	masters, err := podClient.List(metav1.ListOptions{LabelSelector: "name=ovnkube-master"})
+	if err != nil {
+		return 0, err
+	}
+	if len(masters.Items) == 0 {
+		return 0, fmt.Errorf("no ovnkube-master pod found in namespace %s", ovnNamespace)
+	}
+	master := masters.Items[0]
+	node, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, name := range []string{master.Name, node.Name} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			errs <- podClient.Delete(name, metav1.NewDeleteOptions(0))
+		}(name)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	framework.Logf("Deleted ovnkube-master %q and ovnkube-node %q", master.Name, node.Name)
+
+	err = wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+		podList, err := podClient.List(metav1.ListOptions{LabelSelector: "name=ovnkube-master"})
+		if err != nil {
+			return false, nil
+		}
+		masterReplaced := false
+		for _, pod := range podList.Items {
+			if pod.UID != master.UID && pod.Status.Phase == v1.PodRunning && pod.DeletionTimestamp == nil {
+				masterReplaced = true
+			}
+		}
+		pod, err := getOvnkubeNodePod(f, nodeName)
+		return masterReplaced && err == nil && pod.UID != node.UID, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ovnkube-master and ovnkube-node were not both replaced: %v", err)
+	}
+	return time.Since(start), nil
+}
+
 var _ = Describe("e2e control plane", func() {
 	var svcname = "nettest"
 
@@ -218,9 +719,9 @@ var _ = Describe("e2e control plane", func() {
 		ginkgo.By("Running container which tries to connect to 8.8.8.8 in a loop")
 
 		podChan, errChan := make(chan *v1.Pod), make(chan error)
-		go checkContinuousConnectivity(f, "", "connectivity-test-continuous", "8.8.8.8", 53, 30, podChan, errChan)
+		go checkContinuousConnectivity(f, "", "connectivity-test-continuous", "8.8.8.8", 53, 30, 10, 2, podChan, errChan)
 
-		testPod := <-podChan
+		testPod := receiveContinuousConnectivityPod(podChan, errChan)
 		framework.Logf("Test pod running on %q", testPod.Spec.NodeName)
 
 		time.Sleep(5 * time.Second)
@@ -240,6 +741,9 @@ var _ = Describe("e2e control plane", func() {
 		framework.ExpectNoError(err, "should delete ovnkube-node pod")
 		framework.Logf("Deleted ovnkube-node %q", podName)
 
+		err = waitForOvnPodRecreated(f, "ovnkube-node", testPod.Spec.NodeName, 2*time.Minute)
+		framework.ExpectNoError(err, "should recreate the ovnkube-node pod")
+
 		framework.ExpectNoError(<-errChan)
 	})
 
@@ -247,9 +751,9 @@ var _ = Describe("e2e control plane", func() {
 		ginkgo.By("Running container which tries to connect to 8.8.8.8 in a loop")
 
 		podChan, errChan := make(chan *v1.Pod), make(chan error)
-		go checkContinuousConnectivity(f, "", "connectivity-test-continuous", "8.8.8.8", 53, 30, podChan, errChan)
+		go checkContinuousConnectivity(f, "", "connectivity-test-continuous", "8.8.8.8", 53, 30, 10, 2, podChan, errChan)
 
-		testPod := <-podChan
+		testPod := receiveContinuousConnectivityPod(podChan, errChan)
 		framework.Logf("Test pod running on %q", testPod.Spec.NodeName)
 
 		time.Sleep(5 * time.Second)
@@ -257,10 +761,10 @@ var _ = Describe("e2e control plane", func() {
 		podClient := f.ClientSet.CoreV1().Pods("ovn-kubernetes")
 
 		podList, _ := podClient.List(metav1.ListOptions{})
-		podName := ""
+		podName, masterNode := "", ""
 		for _, pod := range podList.Items {
 			if strings.HasPrefix(pod.Name, "ovnkube-master") {
-				podName = pod.Name
+				podName, masterNode = pod.Name, pod.Spec.NodeName
 				break
 			}
 		}
@@ -269,44 +773,111 @@ var _ = Describe("e2e control plane", func() {
 		framework.ExpectNoError(err, "should delete ovnkube-master pod")
 		framework.Logf("Deleted ovnkube-master %q", podName)
 
+		err = waitForOvnPodRecreated(f, "ovnkube-master", masterNode, 2*time.Minute)
+		framework.ExpectNoError(err, "should recreate the ovnkube-master pod")
+
+		framework.ExpectNoError(<-errChan)
+	})
+
+	ginkgo.It("should provide Internet connection continuously when master and ovn-k8s pods are killed together", func() {
+		ginkgo.By("Running container which tries to connect to 8.8.8.8 in a loop")
+
+		podChan, errChan := make(chan *v1.Pod), make(chan error)
+		go checkContinuousConnectivity(f, "", "connectivity-test-continuous", "8.8.8.8", 53, 30, 10, 2, podChan, errChan)
+
+		testPod := receiveContinuousConnectivityPod(podChan, errChan)
+		framework.Logf("Test pod running on %q", testPod.Spec.NodeName)
+
+		time.Sleep(5 * time.Second)
+
+		recovery, err := killMasterAndNodePods(f, testPod.Spec.NodeName)
+		framework.ExpectNoError(err, "should replace the ovnkube-master and ovnkube-node pods")
+		framework.Logf("Control plane recovered %v after the kills", recovery)
+
 		framework.ExpectNoError(<-errChan)
 	})
+
+	ginkgo.It("should keep connectivity while ovnkube-master is cut off from the API server and catch up afterwards", func() {
+		const (
+			serverName    = "apiserver-partition-server"
+			clientName    = "apiserver-partition-client"
+			newServerName = "apiserver-partition-new-server"
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+		server := createServerPod(f, f.Namespace.Name, serverName, serverNode, nil)
+		createClientPod(f, f.Namespace.Name, clientName, clientNode, nil)
+		expectConnectivity(f.Namespace.Name, clientName, server.Status.PodIP, netexecPort)
+
+		ginkgo.By("Blocking the API server connections of the ovnkube-master nodes")
+		// make sure the partition is lifted even if the test fails half way
+		defer setMasterAPIServerBlocked(f, false)
+		if err := setMasterAPIServerBlocked(f, true); errors.Is(err, errNoUnifiedCgroup) {
+			framework.Skipf("Test requires cgroup v2 nodes to block the API server connections of ovnkube-master alone: %v", err)
+		} else {
+			framework.ExpectNoError(err)
+		}
+
+		ginkgo.By("Creating a pod and a service ovnkube-master cannot see during the partition")
+		newLabels := map[string]string{"app": newServerName}
+		_, err = f.ClientSet.CoreV1().Pods(f.Namespace.Name).Create(
+			newAgnhostPod(newServerName, serverNode, newLabels, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)))
+		framework.ExpectNoError(err, "failed to create pod %s", newServerName)
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: newServerName,
+			},
+			Spec: v1.ServiceSpec{
+				Selector: newLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+
+		ginkgo.By("Verifying the existing flows keep forwarding during the partition")
+		for i := 0; i < 10; i++ {
+			_, err := pokeHTTP(f.Namespace.Name, clientName, server.Status.PodIP, netexecPort)
+			framework.ExpectNoError(err, "connectivity broke while ovnkube-master was cut off from the API server")
+			time.Sleep(3 * time.Second)
+		}
+
+		ginkgo.By("Restoring the API server connections and verifying the pending pod and service converge")
+		framework.ExpectNoError(setMasterAPIServerBlocked(f, false))
+		framework.ExpectNoError(e2epod.WaitTimeoutForPodRunningInNamespace(f.ClientSet, newServerName, f.Namespace.Name, 3*time.Minute))
+		newServer, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(newServerName, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", newServerName)
+		expectConnectivity(f.Namespace.Name, clientName, newServer.Status.PodIP, netexecPort)
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, netexecPort)
+		expectConnectivity(f.Namespace.Name, clientName, server.Status.PodIP, netexecPort)
+	})
 })
 
 // Test e2e hybrid sdn inter-node connectivity between worker nodes and validate pods do not traverse the external gateway
 var _ = Describe("test e2e inter-node connectivity between worker nodes hybrid overlay on separate worker nodes", func() {
 	const (
-		svcname          string = "internode-hyb-sdn-e2e"
-		pingTarget       string = "172.17.0.250"
-		ovnNs            string = "ovn-kubernetes"
-		ovnWorkerNode    string = "ovn-worker"
-		ovnWorkerNode2   string = "ovn-worker2"
-		ovnHaWorkerNode2 string = "ovn-control-plane2"
-		ovnHaWorkerNode3 string = "ovn-control-plane3"
-		ovnContainer     string = "ovnkube-node"
-		gwContainerName  string = "gw-test-container-internode"
-		jsonFlag         string = "-o=jsonpath='{.items..metadata.name}'"
-		getPodIPRetry    int    = 20
-	)
-	var (
-		haMode    bool
-		ovnNsFlag = fmt.Sprintf("--namespace=%s", ovnNs)
-		labelFlag = fmt.Sprintf("name=%s", ovnContainer)
+		svcname         string = "internode-hyb-sdn-e2e"
+		pingTarget      string = "172.17.0.250"
+		gwContainerName string = "gw-test-container-internode"
+		getPodIPRetry   int    = 20
 	)
+	var mode clusterMode
 
 	f := framework.NewDefaultFramework(svcname)
 
 	// Determine what mode the CI is running in and get relevant endpoint information for the tests
 	BeforeEach(func() {
-		fieldSelectorFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnWorkerNode)
-		fieldSelectorHaFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnHaWorkerNode2)
-
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		// start the container that will act as an external gateway
-		_, err := runCommand("docker", "run", "-itd", "--privileged", "--name", gwContainerName, "centos")
+		_, err := runCommandWithContext(ctx, "docker", "run", "-itd", "--privileged", "--name", gwContainerName, "centos")
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
-		exVtepIP, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerName)
+		exVtepIP, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerName)
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
@@ -323,30 +894,24 @@ var _ = Describe("test e2e inter-node connectivity between worker nodes hybrid o
 		}
 		// Annotate the pods to route pods to hybrid-sdn bridge br-ext
 		framework.Logf("Annotating the external gateway test namespace")
-		framework.RunKubectlOrDie(annotateArgs...)
-
-		// Attempt to retrieve the pod name that will run the external interface for e2e control-plane non-ha mode
-		kubectlOut, err := framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorFlag)
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnWorkerNode, err)
-		}
-		// Attempt to retrieve the pod name that will run the external interface for e2e control-plane ha mode
-		if kubectlOut == "''" {
-			haMode = true
-			kubectlOut, err = framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorHaFlag)
-			if err != nil {
-				framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnHaWorkerNode2, err)
-			}
-		}
-		// Fail the test if no pod is matched within the specified node
-		if kubectlOut == "''" {
-			framework.Failf("Unable to locate container %s on any known nodes", ovnContainer)
+		if _, err := runKubectlWithContext(ctx, annotateArgs...); err != nil {
+			framework.Failf("failed to annotate the external gateway test namespace: %v", err)
 		}
+
+		mode, err = detectClusterMode(f)
+		framework.ExpectNoError(err, "failed to detect the cluster mode")
+	})
+
+	// delete the test pods before the framework tears the namespace down
+	JustAfterEach(func() {
+		deleteTrackedPods(f)
 	})
 
 	AfterEach(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		// tear down the container simulating the gateway
-		_, err := runCommand("docker", "rm", "-f", gwContainerName)
+		_, err := runCommandWithContext(ctx, "docker", "rm", "-f", gwContainerName)
 		if err != nil {
 			framework.Failf("failed to delete the gateway test container %v", err)
 		}
@@ -356,19 +921,12 @@ var _ = Describe("test e2e inter-node connectivity between worker nodes hybrid o
 		var err error
 		var validIP net.IP
 		var pingTarget string
-		var ciWorkerNodeSrc string
-		var ciWorkerNodeDst string
 		dstPingPodName := "e2e-dst-ping-pod"
 		command := []string{"bash", "-c", "sleep 20000"}
 
-		// non-ha ci mode runs a named set of nodes with a prefix of ovn-worker
-		ciWorkerNodeSrc = ovnWorkerNode
-		ciWorkerNodeDst = ovnWorkerNode2
-		// ha ci mode runs a named set of nodes with a prefix of ovn-control-plane
-		if haMode {
+		ciWorkerNodeSrc, ciWorkerNodeDst := mode.SrcNode, mode.DstNode
+		if mode.HA {
 			framework.Logf("Detected a HA mode KIND environment")
-			ciWorkerNodeSrc = ovnHaWorkerNode2
-			ciWorkerNodeDst = ovnHaWorkerNode3
 		}
 		By(fmt.Sprintf("Creating a container on node %s and verifying connectivity to a pod on node %s", ciWorkerNodeSrc, ciWorkerNodeDst))
 
@@ -397,91 +955,40 @@ var _ = Describe("test e2e inter-node connectivity between worker nodes hybrid o
 		framework.ExpectNoError(
 			checkConnectivityPingToHost(f, ciWorkerNodeSrc, "e2e-src-ping-pod", pingTarget, ipv4PingCommand, 30))
 
-		fieldSelectorFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ciWorkerNodeSrc)
-		kubectlOut, err := framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorFlag)
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ciWorkerNodeSrc, err)
-		}
-		ovnPodName := strings.Trim(kubectlOut, "'")
-		ovnContainerFlag := fmt.Sprintf("--container=%s", ovnContainer)
-		// dump the flowmods from br-ext to verify no counters are hit
-		kubectlOut, err = framework.RunKubectl("exec", ovnPodName, ovnNsFlag, ovnContainerFlag, "--", "ovs-ofctl", "dump-flows", "br-ext")
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnWorkerNode, err)
-		}
-		for _, flowmod := range strings.Split(kubectlOut, "\n") {
-			// filter out irrelevant lines from ofctl output
-			if strings.Contains(flowmod, pingTarget) {
-				// verify no flowmod counters were hit in br-ext for the target
-				if !strings.Contains(flowmod, "n_packets=0") {
-					framework.Failf("Expected packets=0 but found the flow %s", flowmod)
-				}
-			}
-		}
+		// verify no flow counters were hit in br-ext for the target
+		framework.ExpectNoError(assertFlowPacketCount(f, ciWorkerNodeSrc, "br-ext", pingTarget, 0))
 	})
 })
 
 // Test e2e inter-node connectivity over br-int
 var _ = Describe("test e2e inter-node connectivity between worker nodes", func() {
 	const (
-		svcname          string = "inter-node-e2e"
-		ovnNs            string = "ovn-kubernetes"
-		ovnWorkerNode    string = "ovn-worker"
-		ovnWorkerNode2   string = "ovn-worker2"
-		ovnHaWorkerNode2 string = "ovn-control-plane2"
-		ovnHaWorkerNode3 string = "ovn-control-plane3"
-		ovnContainer     string = "ovnkube-node"
-		jsonFlag         string = "-o=jsonpath='{.items..metadata.name}'"
-		getPodIPRetry    int    = 20
+		svcname       string = "inter-node-e2e"
+		getPodIPRetry int    = 20
 	)
 
-	var (
-		haMode    bool
-		ovnNsFlag = fmt.Sprintf("--namespace=%s", ovnNs)
-		labelFlag = fmt.Sprintf("name=%s", ovnContainer)
-	)
+	var mode clusterMode
 
 	f := framework.NewDefaultFramework(svcname)
 
 	// Determine which KIND environment is running by querying the running nodes
 	BeforeEach(func() {
-		fieldSelectorFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnWorkerNode)
-		fieldSelectorHaFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnHaWorkerNode2)
+		var err error
+		mode, err = detectClusterMode(f)
+		framework.ExpectNoError(err, "failed to detect the cluster mode")
+	})
 
-		// Determine if the kind deployment is in HA mode or non-ha mode based on node naming
-		kubectlOut, err := framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorFlag)
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnWorkerNode, err)
-		}
-		if kubectlOut == "''" {
-			haMode = true
-			kubectlOut, err = framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorHaFlag)
-			if err != nil {
-				framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnHaWorkerNode2, err)
-			}
-		}
-		// Fail the test if no pod is matched within the specified node
-		if kubectlOut == "''" {
-			framework.Failf("Unable to locate container %s on any known nodes", ovnContainer)
-		}
+	// delete the test pods before the framework tears the namespace down
+	JustAfterEach(func() {
+		deleteTrackedPods(f)
 	})
 
 	It("Should validate connectivity within a namespace of pods on separate nodes", func() {
-		var err error
-		var validIP net.IP
-		var pingTarget string
-		var ciWorkerNodeSrc string
-		var ciWorkerNodeDst string
 		dstPingPodName := "e2e-dst-ping-pod"
 		command := []string{"bash", "-c", "sleep 20000"}
-		// non-ha ci mode runs a named set of nodes with a prefix of ovn-worker
-		ciWorkerNodeSrc = ovnWorkerNode
-		ciWorkerNodeDst = ovnWorkerNode2
-		// ha ci mode runs a named set of nodes with a prefix of ovn-control-plane
-		if haMode {
+		ciWorkerNodeSrc, ciWorkerNodeDst := mode.SrcNode, mode.DstNode
+		if mode.HA {
 			framework.Logf("Detected a HA mode KIND environment")
-			ciWorkerNodeSrc = ovnHaWorkerNode2
-			ciWorkerNodeDst = ovnHaWorkerNode3
 		}
 		By(fmt.Sprintf("Creating a container on node %s and verifying connectivity to a pod on node %s", ciWorkerNodeSrc, ciWorkerNodeDst))
 
@@ -489,62 +996,106 @@ var _ = Describe("test e2e inter-node connectivity between worker nodes", func()
 		createGenericPod(f, dstPingPodName, ciWorkerNodeDst, command)
 		// There is a condition somewhere with e2e WaitForPodNotPending that returns ready
 		// before calling for the IP address will succeed. This simply adds some retries.
-		for i := 1; i < getPodIPRetry; i++ {
-			pingTarget, err = getPodAddress(dstPingPodName, f.Namespace.Name)
-			if err != nil {
-				framework.Logf("Warning unable to query the test pod on node %s %v", ciWorkerNodeSrc, err)
-			}
-			validIP = net.ParseIP(pingTarget)
-			if validIP != nil {
-				framework.Logf("Destination ping target for %s is %s", dstPingPodName, pingTarget)
-				break
-			}
-			time.Sleep(time.Second * 3)
-			framework.Logf("Retry attempt %d to get pod IP from initializing pod %s", i, dstPingPodName)
-		}
-		// Fail the test if no address is ever retrieved
-		if validIP == nil {
+		pingTarget, err := getPodAddressWithRetry(dstPingPodName, f.Namespace.Name, getPodIPRetry)
+		if err != nil {
 			framework.Failf("Warning: Failed to get an IP for target pod %s, test will fail", dstPingPodName)
 		}
+		framework.Logf("Destination ping target for %s is %s", dstPingPodName, pingTarget)
 		// Spin up another pod that attempts to reach the previously started pod on separate nodes
 		framework.ExpectNoError(
-			checkConnectivityPingToHost(f, ciWorkerNodeSrc, "e2e-src-ping-pod", pingTarget, ipv4PingCommand, 30))
+			checkConnectivityPingToHostAuto(f, ciWorkerNodeSrc, "e2e-src-ping-pod", pingTarget, 30))
+	})
+
+	It("Should validate connectivity from a node to pods on every other worker node", func() {
+		workers, err := getWorkerNodes(f)
+		framework.ExpectNoError(err, "failed to list the worker nodes")
+		if len(workers) < 2 {
+			framework.Skipf("Test requires at least 2 worker nodes, found %v", workers)
+		}
+		srcNode, dstNodes := workers[0], workers[1:]
+		By(fmt.Sprintf("Verifying connectivity from node %s to pods on nodes %v", srcNode, dstNodes))
+		framework.ExpectNoError(checkConnectivityFanOut(f, srcNode, dstNodes, getPodIPRetry))
 	})
 })
 
+// gatewayTestTimeout bounds the commands run by each step of the external gateway tests, so a
+// stuck container command fails the test rather than hanging the suite
+const gatewayTestTimeout = 5 * time.Minute
+
+// setupGatewayVxlan makes the container the vtep of an external gateway: a vxlan interface with
+// the vni towards the node at remoteVtep, the gateway address on lo unless gwCidr is empty, and a
+// route to the pod subnet of the node over the vxlan.
+func setupGatewayVxlan(ctx context.Context, containerName string, vni int, remoteVtep, gwCidr, podCIDR string) error {
+	cmds := [][]string{
+		{"ip", "link", "add", "vxlan0", "type", "vxlan", "dev", "eth0", "id", strconv.Itoa(vni), "dstport", vxlanPort, "remote", remoteVtep},
+		{"ip", "link", "set", "vxlan0", "up"},
+	}
+	if gwCidr != "" {
+		cmds = append(cmds, []string{"ip", "address", "add", gwCidr, "dev", "lo"})
+	}
+	cmds = append(cmds, []string{"ip", "route", "add", podCIDR, "dev", "vxlan0"})
+	for _, cmd := range cmds {
+		if _, err := runCommandWithContext(ctx, append([]string{"docker", "exec", containerName}, cmd...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupProxyARPGateway moves the gateway address of the external gateway container into a
+// network namespace behind a veth pair, and has the container answer ARP for it on the overlay
+// device and forward the traffic to it.
+func setupProxyARPGateway(ctx context.Context, containerName, gwCidr, overlayDev string) error {
+	gwIP, _, err := net.ParseCIDR(gwCidr)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range [][]string{
+		{"ip", "netns", "add", "gwhost"},
+		{"ip", "link", "add", "veth-gw", "type", "veth", "peer", "name", "veth-host"},
+		{"ip", "link", "set", "veth-host", "netns", "gwhost"},
+		{"ip", "netns", "exec", "gwhost", "ip", "address", "add", gwCidr, "dev", "veth-host"},
+		{"ip", "netns", "exec", "gwhost", "ip", "link", "set", "veth-host", "up"},
+		{"ip", "netns", "exec", "gwhost", "ip", "route", "add", "default", "dev", "veth-host"},
+		{"ip", "link", "set", "veth-gw", "up"},
+		{"ip", "route", "add", gwIP.String() + "/32", "dev", "veth-gw"},
+		{"sh", "-c", "echo 1 > /proc/sys/net/ipv4/ip_forward"},
+		{"sh", "-c", "echo 1 > /proc/sys/net/ipv4/conf/veth-gw/proxy_arp"},
+		{"sh", "-c", fmt.Sprintf("echo 1 > /proc/sys/net/ipv4/conf/%s/proxy_arp", overlayDev)},
+	} {
+		if _, err := runCommandWithContext(ctx, append([]string{"docker", "exec", containerName}, cmd...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Verify pods in the namespace annotated with an external-gateway traverse the vxlan
 // overlay and reach the intended external gateway vtep and gateway end to end
 var _ = Describe("e2e external gateway validation", func() {
 	const (
 		svcname         string = "externalgw"
-		ovnNs           string = "ovn-kubernetes"
 		extGW           string = "10.249.0.1"
 		gwContainerName string = "gw-test-container"
-		ovnWorkerNode   string = "ovn-worker"
-		ovnHaWorkerNode string = "ovn-control-plane2"
-		ovnContainer    string = "ovnkube-node"
 	)
 
 	var (
-		haMode    bool
+		mode      clusterMode
 		extGWCidr = fmt.Sprintf("%s/24", extGW)
-		ovnNsFlag = fmt.Sprintf("--namespace=%s", ovnNs)
 	)
 	f := framework.NewDefaultFramework(svcname)
 
 	// Determine what mode the CI is running in and get relevant endpoint information for the tests
 	BeforeEach(func() {
-		labelFlag := fmt.Sprintf("name=%s", ovnContainer)
-		jsonFlag := "-o=jsonpath='{.items..metadata.name}'"
-		fieldSelectorFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnWorkerNode)
-		fieldSelectorHaFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnHaWorkerNode)
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		// start the container that will act as an external gateway
-		_, err := runCommand("docker", "run", "-itd", "--privileged", "--name", gwContainerName, "centos")
+		_, err := runCommandWithContext(ctx, "docker", "run", "-itd", "--privileged", "--name", gwContainerName, "centos")
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
 		// retrieve the container ip of the external gateway container
-		exVtepIP, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerName)
+		exVtepIP, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerName)
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
@@ -565,38 +1116,33 @@ var _ = Describe("e2e external gateway validation", func() {
 		}
 
 		framework.Logf("Annotating the external gateway test namespace")
-		framework.RunKubectlOrDie(annotateArgs...)
-		// attempt to retrieve the pod name that will source the tunnel test in non-HA mode
-		kubectlOut, err := framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorFlag)
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnWorkerNode, err)
-		}
-		// attempt to retrieve the pod name that will source the tunnel test in HA mode
-		if kubectlOut == "''" {
-			haMode = true
-			kubectlOut, err = framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorHaFlag)
-			if err != nil {
-				framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnHaWorkerNode, err)
-			}
+		if _, err := runKubectlWithContext(ctx, annotateArgs...); err != nil {
+			framework.Failf("failed to annotate the external gateway test namespace: %v", err)
 		}
+		mode, err = detectClusterMode(f)
+		framework.ExpectNoError(err, "failed to detect the cluster mode")
+	})
+
+	// delete the test pods before the framework tears the namespace down
+	JustAfterEach(func() {
+		deleteTrackedPods(f)
 	})
 
 	AfterEach(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		// tear down the container simulating the gateway
-		_, err := runCommand("docker", "rm", "-f", gwContainerName)
+		_, err := runCommandWithContext(ctx, "docker", "rm", "-f", gwContainerName)
 		if err != nil {
 			framework.Failf("failed to delete the gateway test container %v", err)
 		}
 	})
 
 	It("Should validate connectivity to the vxlan interface simulating an external gateway and validate traffic was encapsulated", func() {
-		// non-ha ci mode runs a set of kind nodes prefixed with ovn-worker
-		ciWorkerNodeSrc := ovnWorkerNode
-		if haMode {
-			// ha ci mode runs a named set of nodes with a prefix of ovn-control-plane
-			ciWorkerNodeSrc = ovnHaWorkerNode
-		}
-		localVtepIP, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", ciWorkerNodeSrc)
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
+		ciWorkerNodeSrc := mode.SrcNode
+		localVtepIP, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", ciWorkerNodeSrc)
 		if err != nil {
 			framework.Failf("failed to get the node ip address from node %s %v", ciWorkerNodeSrc, err)
 		}
@@ -606,43 +1152,49 @@ var _ = Describe("e2e external gateway validation", func() {
 		}
 		framework.Logf("the pod side vtep node is %s and the ip %s", ciWorkerNodeSrc, localVtepIP)
 		// retrieve the pod cidr for the worker node
-		jsonFlag := "jsonpath='{.metadata.annotations.k8s\\.ovn\\.org/node-subnets}'"
-		kubectlOut, err := framework.RunKubectl("get", "node", ciWorkerNodeSrc, "-o", jsonFlag)
+		podCIDR, err := getNodeSubnet(f, ciWorkerNodeSrc, "default")
 		if err != nil {
 			framework.Failf("Error retrieving the pod cidr from %s %v", ciWorkerNodeSrc, err)
 		}
-		// strip the apostrophe from stdout and parse the pod cidr
-		annotation := strings.Replace(kubectlOut, "'", "", -1)
-		defaultSubnet := make(map[string]string)
-		if err := json.Unmarshal([]byte(annotation), &defaultSubnet); err != nil {
-			framework.Failf("Error parsing the pod cidr from %s %v", ciWorkerNodeSrc, err)
-		}
-		podCIDR := defaultSubnet["default"]
 		framework.Logf("the pod cidr for node %s is %s", ciWorkerNodeSrc, podCIDR)
 		// setup the container to act as an external gateway and vtep
-		_, err = runCommand("docker", "exec", gwContainerName, "ip", "link", "add", "vxlan0", "type", "vxlan", "dev",
-			"eth0", "id", "4097", "dstport", vxlanPort, "remote", localVtepIP)
-		if err != nil {
-			framework.Failf("failed to create the vxlan interface on the test container: %v", err)
+		if err := setupGatewayVxlan(ctx, gwContainerName, *hybridOverlayVNI, localVtepIP, extGWCidr, podCIDR); err != nil {
+			framework.Failf("failed to set up the vxlan on the test container: %v", err)
 		}
-		_, err = runCommand("docker", "exec", gwContainerName, "ip", "link", "set", "vxlan0", "up")
+		// give the container time to come up and stabilize
+		time.Sleep(time.Second * 10)
+		By(fmt.Sprintf("Creating a container on %s and testing end to end traffic to an external gateway", ciWorkerNodeSrc))
+		framework.ExpectNoError(
+			// generate traffic that will being encapsulated and sent to the external gateway.
+			checkConnectivityPingToHost(f, ciWorkerNodeSrc, "external-gateway-e2e", extGW, ipv4PingCommand, 30))
+	})
+
+	It("Should validate connectivity to an external gateway address answered by proxy-ARP on the vxlan interface", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
+		ciWorkerNodeSrc := mode.SrcNode
+		localVtepIP, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", ciWorkerNodeSrc)
 		if err != nil {
-			framework.Failf("failed to enable the vxlan interface on the test container: %v", err)
+			framework.Failf("failed to get the node ip address from node %s %v", ciWorkerNodeSrc, err)
 		}
-		_, err = runCommand("docker", "exec", gwContainerName, "ip", "address", "add", extGWCidr, "dev", "lo")
+		localVtepIP = strings.TrimSuffix(localVtepIP, "\n")
+		podCIDR, err := getNodeSubnet(f, ciWorkerNodeSrc, "default")
 		if err != nil {
-			framework.Failf("failed to add the external gateway ip to dev lo on the test container: %v", err)
+			framework.Failf("Error retrieving the pod cidr from %s %v", ciWorkerNodeSrc, err)
 		}
-		_, err = runCommand("docker", "exec", gwContainerName, "ip", "route", "add", podCIDR, "dev", "vxlan0")
-		if err != nil {
-			framework.Failf("failed to add the pod route on the test container: %v", err)
+		// unlike the plain gateway the container does not own the gateway address, it only
+		// answers ARP for it on vxlan0 and forwards the traffic to the host behind it
+		if err := setupGatewayVxlan(ctx, gwContainerName, *hybridOverlayVNI, localVtepIP, "", podCIDR); err != nil {
+			framework.Failf("failed to set up the vxlan on the test container: %v", err)
+		}
+		if err := setupProxyARPGateway(ctx, gwContainerName, extGWCidr, "vxlan0"); err != nil {
+			framework.Failf("failed to set up the proxy-ARP gateway on the test container: %v", err)
 		}
 		// give the container time to come up and stabilize
 		time.Sleep(time.Second * 10)
-		By(fmt.Sprintf("Creating a container on %s and testing end to end traffic to an external gateway", ciWorkerNodeSrc))
+		By(fmt.Sprintf("Creating a container on %s and testing end to end traffic to the proxy-ARP external gateway", ciWorkerNodeSrc))
 		framework.ExpectNoError(
-			// generate traffic that will being encapsulated and sent to the external gateway.
-			checkConnectivityPingToHost(f, ciWorkerNodeSrc, "external-gateway-e2e", extGW, ipv4PingCommand, 30))
+			checkConnectivityPingToHost(f, ciWorkerNodeSrc, "proxy-arp-gateway-e2e", extGW, ipv4PingCommand, 30))
 	})
 })
 
@@ -653,54 +1205,43 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 		svcname             string = "multiple-externalgw"
 		extGwAlt1           string = "10.249.1.1"
 		extGwAlt2           string = "10.249.2.1"
-		ovnNs               string = "ovn-kubernetes"
-		ovnWorkerNode       string = "ovn-worker"
-		ovnHaWorkerNode     string = "ovn-control-plane2"
-		ovnContainer        string = "ovnkube-node"
 		gwContainerNameAlt1 string = "gw-test-container-alt"
 		gwContainerNameAlt2 string = "gw-test-container-alt2"
 		getPodIPRetry       int    = 20
 	)
 
-	var haMode bool
-	ovnNsFlag := fmt.Sprintf("--namespace=%s", ovnNs)
+	var mode clusterMode
 	f := framework.NewDefaultFramework(svcname)
 
 	// Determine what mode the CI is running in and get relevant endpoint information for the tests
 	BeforeEach(func() {
-		labelFlag := fmt.Sprintf("name=%s", ovnContainer)
-		jsonFlag := "-o=jsonpath='{.items..metadata.name}'"
-		fieldSelectorFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnWorkerNode)
-		fieldSelectorHaFlag := fmt.Sprintf("--field-selector=spec.nodeName=%s", ovnHaWorkerNode)
-		// start the container that will act as an external gateway
-		kubectlOut, err := framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorFlag)
-		if err != nil {
-			framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnWorkerNode, err)
-		}
-		// attempt to retrieve the pod name that will source the tunnel test in HA mode
-		if kubectlOut == "''" {
-			haMode = true
-			kubectlOut, err = framework.RunKubectl("get", "pods", ovnNsFlag, "-l", labelFlag, jsonFlag, fieldSelectorHaFlag)
-			if err != nil {
-				framework.Failf("Expected container %s running on %s error %v", ovnContainer, ovnHaWorkerNode, err)
-			}
-		}
+		var err error
+		mode, err = detectClusterMode(f)
+		framework.ExpectNoError(err, "failed to detect the cluster mode")
+	})
+
+	// delete the test pods before the framework tears the namespace down
+	JustAfterEach(func() {
+		deleteTrackedPods(f)
 	})
 
 	AfterEach(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		// tear down the containers simulating the gateways
-		_, err := runCommand("docker", "rm", "-f", gwContainerNameAlt1)
+		_, err := runCommandWithContext(ctx, "docker", "rm", "-f", gwContainerNameAlt1)
 		if err != nil {
 			framework.Failf("failed to delete the gateway test container %s %v", gwContainerNameAlt1, err)
 		}
-		_, err = runCommand("docker", "rm", "-f", gwContainerNameAlt2)
+		_, err = runCommandWithContext(ctx, "docker", "rm", "-f", gwContainerNameAlt2)
 		if err != nil {
 			framework.Failf("failed to delete the gateway test container %s %v", gwContainerNameAlt2, err)
 		}
 	})
 
 	It("Should validate connectivity before and after updating the namespace annotation to a new vtep and external gateway", func() {
-
+		ctx, cancel := context.WithTimeout(context.Background(), gatewayTestTimeout)
+		defer cancel()
 		var pingSrc string
 		var validIP net.IP
 		extGWCidrAlt1 := fmt.Sprintf("%s/24", extGwAlt1)
@@ -711,12 +1252,12 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 		testContainer := fmt.Sprintf("%s-container", srcPingPodName)
 		testContainerFlag := fmt.Sprintf("--container=%s", testContainer)
 		// start the container that will act as an external gateway
-		_, err := runCommand("docker", "run", "-itd", "--privileged", "--name", gwContainerNameAlt1, "centos")
+		_, err := runCommandWithContext(ctx, "docker", "run", "-itd", "--privileged", "--name", gwContainerNameAlt1, "centos")
 		if err != nil {
 			framework.Failf("failed to start external gateway test container %s: %v", gwContainerNameAlt1, err)
 		}
 		// retrieve the container ip of the external gateway container
-		exVtepIpAlt1, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerNameAlt1)
+		exVtepIpAlt1, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerNameAlt1)
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
@@ -734,14 +1275,11 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 			fmt.Sprintf("k8s.ovn.org/hybrid-overlay-vtep=%s", exVtepIpAlt1),
 		}
 		framework.Logf("Annotating the external gateway test namespace to a new container vtep:%s gw:%s ", exVtepIpAlt1, extGwAlt1)
-		framework.RunKubectlOrDie(annotateArgs...)
-		// non-ha ci mode runs a set of kind nodes prefixed with ovn-worker
-		ciWorkerNodeSrc := ovnWorkerNode
-		if haMode {
-			// ha ci mode runs a named set of nodes with a prefix of ovn-control-plane
-			ciWorkerNodeSrc = ovnHaWorkerNode
-		}
-		localVtepIP, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", ciWorkerNodeSrc)
+		if _, err := runKubectlWithContext(ctx, annotateArgs...); err != nil {
+			framework.Failf("failed to annotate the external gateway test namespace: %v", err)
+		}
+		ciWorkerNodeSrc := mode.SrcNode
+		localVtepIP, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", ciWorkerNodeSrc)
 		if err != nil {
 			framework.Failf("failed to get the node ip address from node %s %v", ciWorkerNodeSrc, err)
 		}
@@ -751,36 +1289,14 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 		}
 		framework.Logf("the pod side vtep node is %s and the ip %s", ciWorkerNodeSrc, localVtepIP)
 		// retrieve the pod cidr for the worker node
-		jsonFlag := "jsonpath='{.metadata.annotations.k8s\\.ovn\\.org/node-subnets}'"
-		kubectlOut, err := framework.RunKubectl("get", "node", ciWorkerNodeSrc, "-o", jsonFlag)
+		podCIDR, err := getNodeSubnet(f, ciWorkerNodeSrc, "default")
 		if err != nil {
 			framework.Failf("Error retrieving the pod cidr from %s %v", ciWorkerNodeSrc, err)
 		}
-		// strip the apostrophe from stdout and parse the pod cidr
-		annotation := strings.Replace(kubectlOut, "'", "", -1)
-		defaultSubnet := make(map[string]string)
-		if err := json.Unmarshal([]byte(annotation), &defaultSubnet); err != nil {
-			framework.Failf("Error parsing the pod cidr from %s %v", ciWorkerNodeSrc, err)
-		}
-		podCIDR := defaultSubnet["default"]
 		framework.Logf("the pod cidr for node %s is %s", ciWorkerNodeSrc, podCIDR)
 		// setup the new container to emulate a gateway with routes, vtep and a loopback interface acting as the gateway
-		_, err = runCommand("docker", "exec", gwContainerNameAlt1, "ip", "link", "add", "vxlan0", "type", "vxlan", "dev",
-			"eth0", "id", "4097", "dstport", vxlanPort, "remote", localVtepIP)
-		if err != nil {
-			framework.Failf("failed to create the vxlan interface on the test container: %v", err)
-		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt1, "ip", "link", "set", "vxlan0", "up")
-		if err != nil {
-			framework.Failf("failed to enable the vxlan interface on the test container: %v", err)
-		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt1, "ip", "address", "add", extGWCidrAlt1, "dev", "lo")
-		if err != nil {
-			framework.Failf("failed to add the external gateway ip to dev lo on the test container: %v", err)
-		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt1, "ip", "route", "add", podCIDR, "dev", "vxlan0")
-		if err != nil {
-			framework.Failf("failed to add the pod route on the test container: %v", err)
+		if err := setupGatewayVxlan(ctx, gwContainerNameAlt1, *hybridOverlayVNI, localVtepIP, extGWCidrAlt1, podCIDR); err != nil {
+			framework.Failf("failed to set up the vxlan on the test container: %v", err)
 		}
 		// Create the pod that will be used as the source for the connectivity test
 		createGenericPod(f, srcPingPodName, ciWorkerNodeSrc, command)
@@ -806,17 +1322,17 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 		time.Sleep(time.Second * 15)
 		// Verify the initial gateway is reachable from the new pod
 		By(fmt.Sprintf("Verifying connectivity to the updated annotation and initial external gateway %s and vtep %s", extGwAlt1, exVtepIpAlt1))
-		kubectlOut, err = framework.RunKubectl("exec", srcPingPodName, frameworkNsFlag, testContainerFlag, "--", "ping", "-w", "40", extGwAlt1)
+		_, err = runKubectlWithContext(ctx, "exec", srcPingPodName, frameworkNsFlag, testContainerFlag, "--", "ping", "-w", "40", extGwAlt1)
 		if err != nil {
-			framework.Failf("Failed to ping the first gateway %s from container %s on node %s: %v", extGwAlt1, ovnContainer, ovnWorkerNode, err)
+			framework.Failf("Failed to ping the first gateway %s from pod %s on node %s: %v", extGwAlt1, srcPingPodName, ciWorkerNodeSrc, err)
 		}
 		// start the container that will act as a new external gateway that the tests will be updated to use
-		_, err = runCommand("docker", "run", "-itd", "--privileged", "--name", gwContainerNameAlt2, "centos")
+		_, err = runCommandWithContext(ctx, "docker", "run", "-itd", "--privileged", "--name", gwContainerNameAlt2, "centos")
 		if err != nil {
 			framework.Failf("failed to start external gateway test container %s: %v", gwContainerNameAlt2, err)
 		}
 		// retrieve the container ip of the external gateway container
-		exVtepIpAlt2, err := runCommand("docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerNameAlt2)
+		exVtepIpAlt2, err := runCommandWithContext(ctx, "docker", "inspect", "-f", "{{ .NetworkSettings.IPAddress }}", gwContainerNameAlt2)
 		if err != nil {
 			framework.Failf("failed to start external gateway test container: %v", err)
 		}
@@ -835,31 +1351,19 @@ var _ = Describe("e2e multiple external gateway update validation", func() {
 			"--overwrite",
 		}
 		framework.Logf("Annotating the external gateway test namespace to a new container vtep:%s gw:%s ", exVtepIpAlt2, extGwAlt2)
-		framework.RunKubectlOrDie(annotateArgs...)
-		// setup the new container to emulate a gateway with routes, vtep and a loopback interface acting as the gateway
-		_, err = runCommand("docker", "exec", gwContainerNameAlt2, "ip", "link", "add", "vxlan0", "type", "vxlan", "dev",
-			"eth0", "id", "4097", "dstport", vxlanPort, "remote", localVtepIP)
-		if err != nil {
-			framework.Failf("failed to create the vxlan interface on the test container: %v", err)
-		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt2, "ip", "link", "set", "vxlan0", "up")
-		if err != nil {
-			framework.Failf("failed to enable the vxlan interface on the test container: %v", err)
+		if _, err := runKubectlWithContext(ctx, annotateArgs...); err != nil {
+			framework.Failf("failed to annotate the external gateway test namespace: %v", err)
 		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt2, "ip", "address", "add", extGWCidrAlt2, "dev", "lo")
-		if err != nil {
-			framework.Failf("failed to add the external gateway ip to dev lo on the test container: %v", err)
-		}
-		_, err = runCommand("docker", "exec", gwContainerNameAlt2, "ip", "route", "add", podCIDR, "dev", "vxlan0")
-		if err != nil {
-			framework.Failf("failed to add the pod route on the test container: %v", err)
+		// setup the new container to emulate a gateway with routes, vtep and a loopback interface acting as the gateway
+		if err := setupGatewayVxlan(ctx, gwContainerNameAlt2, *hybridOverlayVNI, localVtepIP, extGWCidrAlt2, podCIDR); err != nil {
+			framework.Failf("failed to set up the vxlan on the test container: %v", err)
 		}
 		time.Sleep(time.Second * 40)
 		// Verify the updated gateway is reachable from the initial pod
 		By(fmt.Sprintf("Verifying connectivity to the updated annotation and new external gateway %s and vtep %s", extGwAlt2, exVtepIpAlt2))
-		kubectlOut, err = framework.RunKubectl("exec", srcPingPodName, frameworkNsFlag, testContainerFlag, "--", "ping", "-w", "40", extGwAlt2)
+		_, err = runKubectlWithContext(ctx, "exec", srcPingPodName, frameworkNsFlag, testContainerFlag, "--", "ping", "-w", "40", extGwAlt2)
 		if err != nil {
-			framework.Failf("Failed to ping the second gateway %s from container %s on node %s: %v", extGwAlt2, ovnContainer, ovnWorkerNode, err)
+			framework.Failf("Failed to ping the second gateway %s from pod %s on node %s: %v", extGwAlt2, srcPingPodName, ciWorkerNodeSrc, err)
 		}
 	})
 })
@@ -33,4 +33,5 @@ require (
 	k8s.io/apimachinery v0.17.4
 	k8s.io/kubectl v0.0.0
 	k8s.io/kubernetes v1.17.2
+	k8s.io/utils v0.0.0-20191114184206-e782cd3c129f
 )
@@ -0,0 +1,187 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+	e2eservice "k8s.io/kubernetes/test/e2e/framework/service"
+	imageutils "k8s.io/kubernetes/test/utils/image"
+)
+
+// ingressNginxConfig renders the rules of the ingress into nginx server blocks proxying each path
+// to the ClusterIP of its backend service, the way an ingress controller would.
+func ingressNginxConfig(f *framework.Framework, ing *networkingv1beta1.Ingress) (string, error) {
+	var b strings.Builder
+	for _, rule := range ing.Spec.Rules {
+		fmt.Fprintf(&b, "server {\n  listen 80;\n  server_name %s;\n", rule.Host)
+		for _, path := range rule.HTTP.Paths {
+			svc, err := f.ClientSet.CoreV1().Services(ing.Namespace).Get(path.Backend.ServiceName, metav1.GetOptions{})
+			if err != nil {
+				return "", err
+			}
+			// the trailing slash makes nginx strip the path of the rule from the proxied request
+			fmt.Fprintf(&b, "  location %s {\n    proxy_pass http://%s/;\n  }\n", path.Path,
+				net.JoinHostPort(svc.Spec.ClusterIP, path.Backend.ServicePort.String()))
+		}
+		b.WriteString("}\n")
+	}
+	return b.String(), nil
+}
+
+// createIngressController runs an nginx pod serving the configuration and exposes it with a
+// NodePort service, returning the service.
+func createIngressController(f *framework.Framework, name, config string) *v1.Service {
+	controllerLabels := map[string]string{"app": name}
+	_, err := f.ClientSet.CoreV1().ConfigMaps(f.Namespace.Name).Create(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Data: map[string]string{"default.conf": config},
+	})
+	framework.ExpectNoError(err, "failed to create the configuration of ingress controller %s", name)
+
+	f.PodClient().CreateSync(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: controllerLabels,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:         name,
+				Image:        imageutils.GetE2EImage(imageutils.Nginx),
+				Ports:        []v1.ContainerPort{{ContainerPort: 80}},
+				VolumeMounts: []v1.VolumeMount{{Name: "config", MountPath: "/etc/nginx/conf.d"}},
+			}},
+			Volumes: []v1.Volume{{
+				Name: "config",
+				VolumeSource: v1.VolumeSource{
+					ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: name}},
+				},
+			}},
+		},
+	})
+
+	svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1.ServiceSpec{
+			Type:     v1.ServiceTypeNodePort,
+			Selector: controllerLabels,
+			Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(80)}},
+		},
+	})
+	framework.ExpectNoError(err, "failed to create the service of ingress controller %s", name)
+	return svc
+}
+
+// requestThroughIngress sends an HTTP request for the virtual host and path from the host network
+// of the KIND node to the ingress controller NodePort at nodeIP, returning the response body.
+func requestThroughIngress(fromNode, nodeIP string, nodePort int, host, path string) (string, error) {
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(nodeIP, strconv.Itoa(nodePort)), path)
+	return runCommand("docker", "exec", fromNode, "curl", "--connect-timeout", "2", "--max-time", "5", "-s", "-f",
+		"-H", "Host: "+host, url)
+}
+
+// Validate external traffic reaches pods through an ingress controller
+var _ = Describe("e2e ingress connectivity", func() {
+	const (
+		svcname string = "ingress-connectivity"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should reach the backends of an ingress rule from outside the cluster through the ingress controller", func() {
+		const (
+			controllerName string = "ingress-controller"
+			ingressHost    string = "ingress.example.com"
+			ingressPath    string = "/app/"
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, ingressNode := nodes.Items[0], nodes.Items[1]
+		var ingressNodeIP string
+		for _, address := range ingressNode.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				ingressNodeIP = address.Address
+			}
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "ingress-backend")
+		_, err = jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(2)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to run the ingress backends")
+		backendSvc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the backend service")
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the ingress backends")
+		backends := sets.NewString()
+		for _, pod := range podList.Items {
+			backends.Insert(pod.Name)
+		}
+
+		By(fmt.Sprintf("Routing %s%s to service %s with an ingress", ingressHost, ingressPath, backendSvc.Name))
+		ing, err := f.ClientSet.NetworkingV1beta1().Ingresses(f.Namespace.Name).Create(&networkingv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: backendSvc.Name,
+			},
+			Spec: networkingv1beta1.IngressSpec{
+				Rules: []networkingv1beta1.IngressRule{{
+					Host: ingressHost,
+					IngressRuleValue: networkingv1beta1.IngressRuleValue{
+						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+							Paths: []networkingv1beta1.HTTPIngressPath{{
+								Path: ingressPath,
+								Backend: networkingv1beta1.IngressBackend{
+									ServiceName: backendSvc.Name,
+									ServicePort: intstr.FromInt(jigServicePort),
+								},
+							}},
+						},
+					},
+				}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the ingress")
+		config, err := ingressNginxConfig(f, ing)
+		framework.ExpectNoError(err, "failed to render the ingress %s", ing.Name)
+		controllerSvc := createIngressController(f, controllerName, config)
+		nodePort := int(controllerSvc.Spec.Ports[0].NodePort)
+
+		By(fmt.Sprintf("Requesting %s%s from node %s through NodePort %s:%d", ingressHost, ingressPath, clientNode.Name, ingressNodeIP, nodePort))
+		var answer string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			answer, err = requestThroughIngress(clientNode.Name, ingressNodeIP, nodePort, ingressHost, ingressPath+"hostname")
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "node %s did not reach the ingress backends", clientNode.Name)
+		if answer = strings.TrimSpace(answer); !backends.Has(answer) {
+			framework.Failf("Ingress request was answered by %q instead of one of the backends %v", answer, backends.List())
+		}
+
+		By("Verifying requests outside of the ingress rule are not routed to the backends")
+		if out, err := requestThroughIngress(clientNode.Name, ingressNodeIP, nodePort, ingressHost, "/hostname"); err == nil {
+			framework.Failf("Request outside of the ingress path %s was answered with %q", ingressPath, out)
+		}
+	})
+})
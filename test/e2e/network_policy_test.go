@@ -0,0 +1,668 @@
+package e2e_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+)
+
+// createNetworkPolicy creates the policy in the namespace, failing the test on error
+func createNetworkPolicy(f *framework.Framework, namespace string, policy *networkingv1.NetworkPolicy) *networkingv1.NetworkPolicy {
+	created, err := f.ClientSet.NetworkingV1().NetworkPolicies(namespace).Create(policy)
+	framework.ExpectNoError(err, "failed to create network policy %s in namespace %s", policy.Name, namespace)
+	return created
+}
+
+// createMultiPortServerPod creates a pod answering HTTP on every given port with the agnhost porter
+// and waits for it to be running.
+func createMultiPortServerPod(f *framework.Framework, namespace, podName string, labels map[string]string, ports []int) *v1.Pod {
+	pod := newAgnhostPod(podName, "", labels, "porter")
+	for _, port := range ports {
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, v1.EnvVar{
+			Name:  fmt.Sprintf("SERVE_PORT_%d", port),
+			Value: fmt.Sprintf("%s-%d", podName, port),
+		})
+	}
+	return f.PodClientNS(namespace).CreateSync(pod)
+}
+
+// allowFromPodOnPortPolicy returns a policy allowing TCP ingress to the selected pods on a
+// single port from the pods matching the client labels.
+func allowFromPodOnPortPolicy(name string, podSelector, clientSelector map[string]string, port int) *networkingv1.NetworkPolicy {
+	protocol := v1.ProtocolTCP
+	policyPort := intstr.FromInt(port)
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: podSelector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &policyPort}},
+				From: []networkingv1.NetworkPolicyPeer{{
+					PodSelector: &metav1.LabelSelector{MatchLabels: clientSelector},
+				}},
+			}},
+		},
+	}
+}
+
+// getNamespaceAddressSetIPs returns the addresses of the address set OVN keeps for the pods of the namespace
+func getNamespaceAddressSetIPs(f *framework.Framework, namespace string) ([]string, error) {
+	dbPod, err := getOvnDBPodName(f)
+	if err != nil {
+		return nil, err
+	}
+	return getNamespaceAddressSetIPsInPod(dbPod, namespace)
+}
+
+// getNamespaceAddressSetIPsInPod returns the addresses of the namespace address set as read
+// from the ovnkube-db pod
+func getNamespaceAddressSetIPsInPod(dbPod, namespace string) ([]string, error) {
+	out, err := runNbctlInPod(dbPod, "--data=bare", "--no-heading", "--columns=addresses", "find", "address_set", "external_ids:name="+namespace)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// assertNamespaceAddressSet waits for the address set of the namespace, read from the ovnkube-db
+// pod, to hold exactly the wanted IPs, failing the test if it does not converge.
+func assertNamespaceAddressSet(ovnPodName, namespace string, wantIPs []string) {
+	want := sets.NewString(wantIPs...)
+	var got sets.String
+	err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		ips, err := getNamespaceAddressSetIPsInPod(ovnPodName, namespace)
+		if err != nil {
+			framework.Logf("Unable to read the address set of namespace %s: %v", namespace, err)
+			return false, nil
+		}
+		got = sets.NewString(ips...)
+		return got.Equal(want), nil
+	})
+	framework.ExpectNoError(err, "address set of namespace %s holds %v, expected %v", namespace, got.List(), want.List())
+}
+
+// manyLabels returns count labels named <prefix>-<i> with value v<i>
+func manyLabels(prefix string, count int) map[string]string {
+	labels := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		labels[fmt.Sprintf("%s-%d", prefix, i)] = fmt.Sprintf("v%d", i)
+	}
+	return labels
+}
+
+// churnPolicyWhileProbing deletes and immediately recreates the policy rounds times while the
+// probe logger pod keeps requesting its target, and returns the requests logged during the churn.
+func churnPolicyWhileProbing(f *framework.Framework, policy *networkingv1.NetworkPolicy, rounds int, probePodName string) ([]probeRecord, error) {
+	policies := f.ClientSet.NetworkingV1().NetworkPolicies(f.Namespace.Name)
+	start := time.Now().Unix()
+	for round := 0; round < rounds; round++ {
+		if err := policies.Delete(policy.Name, &metav1.DeleteOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to delete policy %s in round %d: %v", policy.Name, round, err)
+		}
+		if _, err := policies.Create(policy); err != nil {
+			return nil, fmt.Errorf("failed to recreate policy %s in round %d: %v", policy.Name, round, err)
+		}
+		time.Sleep(time.Second)
+	}
+	// let the probes of the last round make it into the log
+	time.Sleep(5 * time.Second)
+	records, err := getProbeRecords(f, probePodName)
+	if err != nil {
+		return nil, err
+	}
+	var during []probeRecord
+	for _, record := range records {
+		if record.at >= start {
+			during = append(during, record)
+		}
+	}
+	return during, nil
+}
+
+// burstNamespace is a namespace created by createNamespaceBurst, holding a server isolated by a
+// policy admitting only the allowed client of the same namespace, and a denied client
+type burstNamespace struct {
+	name     string
+	serverIP string
+}
+
+const (
+	burstServerName  = "burst-server"
+	burstAllowedName = "burst-allowed"
+	burstDeniedName  = "burst-denied"
+)
+
+// createNamespaceBurst creates count namespaces along with their pods and policies all at once,
+// then waits for the pods to run.
+func createNamespaceBurst(f *framework.Framework, baseName string, count int) []burstNamespace {
+	serverLabels := map[string]string{"app": burstServerName}
+	clientLabels := map[string]string{"netpol-client": "allowed"}
+	namespaces := make([]*v1.Namespace, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer GinkgoRecover()
+			defer wg.Done()
+			ns, err := f.ClientSet.CoreV1().Namespaces().Create(&v1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: fmt.Sprintf("%s-%d-", baseName, i)},
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			namespaces[i] = ns
+			if err := framework.WaitForDefaultServiceAccountInNamespace(f.ClientSet, ns.Name); err != nil {
+				errs[i] = err
+				return
+			}
+			pods := []*v1.Pod{
+				newAgnhostPod(burstServerName, "", serverLabels, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)),
+				newAgnhostPod(burstAllowedName, "", clientLabels, "pause"),
+				newAgnhostPod(burstDeniedName, "", nil, "pause"),
+			}
+			for _, pod := range pods {
+				if _, err := f.ClientSet.CoreV1().Pods(ns.Name).Create(pod); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			_, errs[i] = f.ClientSet.NetworkingV1().NetworkPolicies(ns.Name).Create(
+				allowFromPodOnPortPolicy("allow-burst-client", serverLabels, clientLabels, netexecPort))
+		}(i)
+	}
+	wg.Wait()
+	for i, ns := range namespaces {
+		if ns != nil {
+			f.AddNamespacesToDelete(ns)
+		}
+		framework.ExpectNoError(errs[i], "failed to populate namespace %d of the burst", i)
+	}
+
+	burst := make([]burstNamespace, count)
+	for i, ns := range namespaces {
+		for _, podName := range []string{burstServerName, burstAllowedName, burstDeniedName} {
+			framework.ExpectNoError(e2epod.WaitForPodNameRunningInNamespace(f.ClientSet, podName, ns.Name))
+		}
+		server, err := f.ClientSet.CoreV1().Pods(ns.Name).Get(burstServerName, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get the server of namespace %s", ns.Name)
+		burst[i] = burstNamespace{name: ns.Name, serverIP: server.Status.PodIP}
+	}
+	return burst
+}
+
+// sampleNamespaceConnectivity probes every namespace of the burst once: its allowed client must
+// reach its server, while its denied client and the allowed client of the next namespace must
+// not. It returns the probes that did not behave as expected.
+func sampleNamespaceConnectivity(burst []burstNamespace) []string {
+	var failures []string
+	for i, ns := range burst {
+		if _, err := pokeHTTP(ns.name, burstAllowedName, ns.serverIP, netexecPort); err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%s did not reach its server: %v", ns.name, burstAllowedName, err))
+		}
+		if _, err := pokeHTTP(ns.name, burstDeniedName, ns.serverIP, netexecPort); err == nil {
+			failures = append(failures, fmt.Sprintf("%s/%s reached its server", ns.name, burstDeniedName))
+		}
+		if len(burst) > 1 {
+			next := burst[(i+1)%len(burst)]
+			if _, err := pokeHTTP(ns.name, burstAllowedName, next.serverIP, netexecPort); err == nil {
+				failures = append(failures, fmt.Sprintf("%s/%s reached the server of %s", ns.name, burstAllowedName, next.name))
+			}
+		}
+	}
+	return failures
+}
+
+// sampleCrossNamespaceConnectivity probes the servers of the burst from the allowed clients of
+// random other namespaces, which carry the very labels the policies of the servers admit but from
+// another namespace, and returns the probes that reached a server.
+func sampleCrossNamespaceConnectivity(burst []burstNamespace, pairs int) []string {
+	var failures []string
+	if len(burst) < 2 {
+		return nil
+	}
+	for i := 0; i < pairs; i++ {
+		s := rand.Intn(len(burst))
+		src, dst := burst[s], burst[(s+1+rand.Intn(len(burst)-1))%len(burst)]
+		if _, err := pokeHTTP(src.name, burstAllowedName, dst.serverIP, netexecPort); err == nil {
+			failures = append(failures, fmt.Sprintf("%s/%s reached the server of %s", src.name, burstAllowedName, dst.name))
+		}
+	}
+	return failures
+}
+
+// ovnkubeMasterLogPath is where the ovnkube-master container writes its log
+const ovnkubeMasterLogPath = "/var/log/ovn-kubernetes/ovnkube-master.log"
+
+// getOvnkubeMasterRestarts returns the restart count of the ovnkube-master container of every
+// ovnkube-master pod, by pod name
+func getOvnkubeMasterRestarts(f *framework.Framework) (map[string]int32, error) {
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-master"})
+	if err != nil {
+		return nil, err
+	}
+	restarts := make(map[string]int32, len(podList.Items))
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == "ovnkube-master" {
+				restarts[pod.Name] = status.RestartCount
+			}
+		}
+	}
+	return restarts, nil
+}
+
+// grepOvnkubeMasterLog returns the lines of the ovnkube-master pod log containing the text
+func grepOvnkubeMasterLog(podName, text string) ([]string, error) {
+	out, err := framework.RunKubectl("exec", podName, "--namespace="+ovnNamespace, "--container=ovnkube-master", "--",
+		"sh", "-c", fmt.Sprintf("grep -F -e '%s' %s || true", text, ovnkubeMasterLogPath))
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// assertOvnkubeMasterSurvived fails the test if any ovnkube-master container restarted since the
+// restart counts were taken or logged a panic.
+func assertOvnkubeMasterSurvived(f *framework.Framework, restartsBefore map[string]int32) {
+	restarts, err := getOvnkubeMasterRestarts(f)
+	framework.ExpectNoError(err, "failed to get the ovnkube-master pods")
+	for podName, before := range restartsBefore {
+		after, ok := restarts[podName]
+		if !ok {
+			framework.Failf("ovnkube-master pod %s is gone", podName)
+		}
+		if after != before {
+			framework.Failf("ovnkube-master in pod %s restarted %d times", podName, after-before)
+		}
+		panics, err := grepOvnkubeMasterLog(podName, "panic")
+		framework.ExpectNoError(err, "failed to read the log of %s", podName)
+		if len(panics) > 0 {
+			framework.Failf("ovnkube-master in pod %s panicked:\n%s", podName, strings.Join(panics, "\n"))
+		}
+	}
+}
+
+// how ovn-controller reports a logical flow whose match it cannot parse, which it then skips
+const ovnMatchParseError = "error parsing match"
+
+// assertMatchRejected waits for the ovn-controller of the node to report that it could not parse
+// the match of a logical flow containing text, failing the test if it never does.
+func assertMatchRejected(f *framework.Framework, nodeName, text string) {
+	ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+	framework.ExpectNoError(err)
+	err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		logs, err := e2epod.GetPodLogs(f.ClientSet, ovnNamespace, ovnkubeNode.Name, "ovn-controller")
+		if err != nil {
+			framework.Logf("Failed to get the ovn-controller log of %s: %v", ovnkubeNode.Name, err)
+			return false, nil
+		}
+		for _, line := range strings.Split(logs, "\n") {
+			if strings.Contains(line, ovnMatchParseError) && strings.Contains(line, text) {
+				framework.Logf("ovn-controller on node %s rejected the match: %s", nodeName, strings.TrimSpace(line))
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	framework.ExpectNoError(err, "ovn-controller on node %s did not report a match containing %s as unparsable", nodeName, text)
+}
+
+// Validate that OVN translates network policy peers correctly
+var _ = Describe("e2e network policy", func() {
+	const (
+		svcname    string = "netpol"
+		serverName string = "netpol-server"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should only allow ingress from pods matching both the namespaceSelector and the podSelector of a peer", func() {
+		nsLabels := map[string]string{"netpol-role": "allowed"}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+		otherLabels := map[string]string{"netpol-client": "denied"}
+
+		allowedNs, err := f.CreateNamespace(svcname+"-allowed", nsLabels)
+		framework.ExpectNoError(err, "failed to create the allowed namespace")
+		deniedNs, err := f.CreateNamespace(svcname+"-denied", map[string]string{"netpol-role": "denied"})
+		framework.ExpectNoError(err, "failed to create the denied namespace")
+
+		server := createServerPod(f, f.Namespace.Name, serverName, "", map[string]string{"app": serverName})
+		serverIP := server.Status.PodIP
+		framework.Logf("Server pod %s has IP %s", serverName, serverIP)
+
+		// every client but the first one violates at least one half of the peer
+		clients := []struct {
+			namespace string
+			name      string
+			labels    map[string]string
+		}{
+			{allowedNs.Name, "client-allowed-ns-allowed-pod", clientLabels},
+			{allowedNs.Name, "client-allowed-ns-denied-pod", otherLabels},
+			{deniedNs.Name, "client-denied-ns-allowed-pod", clientLabels},
+			{f.Namespace.Name, "client-server-ns-allowed-pod", clientLabels},
+		}
+		for _, c := range clients {
+			createClientPod(f, c.namespace, c.name, "", c.labels)
+		}
+
+		By("Verifying every client reaches the server before the policy is applied")
+		for _, c := range clients {
+			expectConnectivity(c.namespace, c.name, serverIP, netexecPort)
+		}
+
+		By("Creating a policy with a single peer combining a namespaceSelector and a podSelector")
+		createNetworkPolicy(f, f.Namespace.Name, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "allow-from-client-in-allowed-ns",
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": serverName}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: nsLabels},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: clientLabels},
+					}},
+				}},
+			},
+		})
+
+		By(fmt.Sprintf("Verifying only %s/%s still reaches the server", clients[0].namespace, clients[0].name))
+		expectConnectivity(clients[0].namespace, clients[0].name, serverIP, netexecPort)
+		for _, c := range clients[1:] {
+			expectNoConnectivity(c.namespace, c.name, serverIP, netexecPort)
+		}
+	})
+
+	It("Should allow the union of the ports of overlapping policies selecting the same source", func() {
+		serverLabels := map[string]string{"app": serverName}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+		allowedPorts := []int{8080, 8081}
+		deniedPort := 8082
+		allPorts := []int{8080, 8081, deniedPort}
+
+		server := createMultiPortServerPod(f, f.Namespace.Name, serverName, serverLabels, allPorts)
+		serverIP := server.Status.PodIP
+		createClientPod(f, f.Namespace.Name, "overlap-client", "", clientLabels)
+
+		for _, port := range allPorts {
+			expectConnectivity(f.Namespace.Name, "overlap-client", serverIP, port)
+		}
+
+		By("Creating one allow policy per port for the same client")
+		for _, port := range allowedPorts {
+			createNetworkPolicy(f, f.Namespace.Name, allowFromPodOnPortPolicy(fmt.Sprintf("allow-client-port-%d", port), serverLabels, clientLabels, port))
+		}
+
+		By("Verifying every allowed port is reachable and the remaining port is blocked")
+		for _, port := range allowedPorts {
+			expectConnectivity(f.Namespace.Name, "overlap-client", serverIP, port)
+		}
+		expectNoConnectivity(f.Namespace.Name, "overlap-client", serverIP, deniedPort)
+	})
+
+	It("Should re-evaluate a policy when the labels of a source pod stop matching it", func() {
+		serverLabels := map[string]string{"app": serverName}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+
+		server := createServerPod(f, f.Namespace.Name, serverName, "", serverLabels)
+		serverIP := server.Status.PodIP
+		createClientPod(f, f.Namespace.Name, "relabel-client", "", clientLabels)
+
+		createNetworkPolicy(f, f.Namespace.Name, allowFromPodOnPortPolicy("allow-client", serverLabels, clientLabels, netexecPort))
+
+		By("Verifying the client matching the policy reaches the server")
+		expectConnectivity(f.Namespace.Name, "relabel-client", serverIP, netexecPort)
+
+		By("Relabeling the client so that it no longer matches the policy")
+		framework.RunKubectlOrDie("label", "pod", "relabel-client", "--namespace="+f.Namespace.Name, "netpol-client=denied", "--overwrite")
+
+		By("Verifying the client is denied once the policy is re-evaluated")
+		expectNoConnectivity(f.Namespace.Name, "relabel-client", serverIP, netexecPort)
+	})
+
+	It("Should scope a pod recreated in another namespace to the state and policies of its new namespace", func() {
+		const moverName string = "mover"
+		moverLabels := map[string]string{"app": moverName}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+		oldNs := f.Namespace.Name
+		newNs, err := f.CreateNamespace(svcname+"-new", nil)
+		framework.ExpectNoError(err, "failed to create the new namespace")
+
+		createServerPod(f, oldNs, moverName, "", moverLabels)
+		createNetworkPolicy(f, newNs.Name, allowFromPodOnPortPolicy("allow-client", moverLabels, clientLabels, netexecPort))
+
+		By(fmt.Sprintf("Moving pod %s from namespace %s to namespace %s", moverName, oldNs, newNs.Name))
+		f.PodClientNS(oldNs).DeleteSync(moverName, nil, framework.PodDeleteTimeout)
+		mover := createServerPod(f, newNs.Name, moverName, "", moverLabels)
+
+		By(fmt.Sprintf("Verifying no OVN state of pod %s lingers in namespace %s", moverName, oldNs))
+		var lingering []string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			lingering = nil
+			if exists, err := logicalPortExists(f, oldNs+"_"+moverName); err != nil || exists {
+				lingering = append(lingering, "logical port "+oldNs+"_"+moverName)
+			}
+			if ips, err := getNamespaceAddressSetIPs(f, oldNs); err != nil || len(ips) > 0 {
+				lingering = append(lingering, fmt.Sprintf("address set addresses %v", ips))
+			}
+			return len(lingering) == 0, nil
+		})
+		framework.ExpectNoError(err, "namespace %s still holds the state of the moved pod: %s", oldNs, strings.Join(lingering, ", "))
+		exists, err := logicalPortExists(f, newNs.Name+"_"+moverName)
+		framework.ExpectNoError(err, "failed to look up the logical port of the moved pod")
+		if !exists {
+			framework.Failf("Moved pod %s has no logical port in namespace %s", moverName, newNs.Name)
+		}
+		ips, err := getNamespaceAddressSetIPs(f, newNs.Name)
+		framework.ExpectNoError(err, "failed to get the address set of namespace %s", newNs.Name)
+		if len(ips) != 1 || ips[0] != mover.Status.PodIP {
+			framework.Failf("Address set of namespace %s holds %v instead of the moved pod IP %s", newNs.Name, ips, mover.Status.PodIP)
+		}
+
+		By(fmt.Sprintf("Verifying the policy of namespace %s applies to the moved pod", newNs.Name))
+		createClientPod(f, newNs.Name, "mover-allowed-client", "", clientLabels)
+		createClientPod(f, oldNs, "mover-denied-client", "", nil)
+		expectConnectivity(newNs.Name, "mover-allowed-client", mover.Status.PodIP, netexecPort)
+		expectNoConnectivity(oldNs, "mover-denied-client", mover.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep the namespace address set equal to the IPs of the pods of the namespace", func() {
+		dbPod, err := getOvnDBPodName(f)
+		framework.ExpectNoError(err, "failed to find an ovnkube-db pod")
+
+		var podIPs []string
+		for i := 0; i < 3; i++ {
+			pod := createClientPod(f, f.Namespace.Name, fmt.Sprintf("addrset-pod-%d", i), "", nil)
+			podIPs = append(podIPs, pod.Status.PodIP)
+		}
+
+		By(fmt.Sprintf("Verifying the address set of namespace %s holds the IPs of its pods", f.Namespace.Name))
+		assertNamespaceAddressSet(dbPod, f.Namespace.Name, podIPs)
+
+		By("Deleting a pod and verifying its IP leaves the address set")
+		f.PodClient().DeleteSync("addrset-pod-0", nil, framework.PodDeleteTimeout)
+		assertNamespaceAddressSet(dbPod, f.Namespace.Name, podIPs[1:])
+	})
+
+	It("Should match a policy peer selecting a subset of the labels of pods carrying hundreds of labels", func() {
+		const (
+			labelCount    = 300
+			selectorCount = 20
+		)
+		serverLabels := map[string]string{"app": serverName}
+		allowedLabels := manyLabels("netpol-many", labelCount)
+		selector := make(map[string]string, selectorCount)
+		for i := 0; i < selectorCount; i++ {
+			key := fmt.Sprintf("netpol-many-%d", i*labelCount/selectorCount)
+			selector[key] = allowedLabels[key]
+		}
+		// the denied client carries the same labels except for one of the selected ones
+		deniedLabels := manyLabels("netpol-many", labelCount)
+		deniedLabels["netpol-many-0"] = "other"
+
+		server := createServerPod(f, f.Namespace.Name, serverName, "", serverLabels)
+		serverIP := server.Status.PodIP
+		createClientPod(f, f.Namespace.Name, "many-labels-allowed", "", allowedLabels)
+		createClientPod(f, f.Namespace.Name, "many-labels-denied", "", deniedLabels)
+
+		By(fmt.Sprintf("Creating a policy selecting clients on %d of their %d labels", selectorCount, labelCount))
+		createNetworkPolicy(f, f.Namespace.Name, allowFromPodOnPortPolicy("allow-many-labels", serverLabels, selector, netexecPort))
+
+		By("Verifying only the client matching every selected label reaches the server")
+		expectConnectivity(f.Namespace.Name, "many-labels-allowed", serverIP, netexecPort)
+		expectNoConnectivity(f.Namespace.Name, "many-labels-denied", serverIP, netexecPort)
+	})
+
+	It("Should keep applying the valid rules of a policy whose ipBlock CIDR is malformed", func() {
+		const (
+			// not a canonical CIDR, its host bits are set, which the API server accepts and OVN does not
+			hostBitsCIDR string = "192.0.2.1/24"
+			allowedName  string = "malformed-allowed-client"
+			deniedName   string = "malformed-denied-client"
+		)
+		serverLabels := map[string]string{"app": serverName}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+		ipBlockPolicy := func(name, cidr string) *networkingv1.NetworkPolicy {
+			return &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: name,
+				},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: serverLabels},
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{
+						{From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}}},
+						{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: clientLabels}}}},
+					},
+				},
+			}
+		}
+
+		By("Verifying the API server rejects an unparsable ipBlock CIDR")
+		_, err := f.ClientSet.NetworkingV1().NetworkPolicies(f.Namespace.Name).Create(ipBlockPolicy("unparsable-cidr", "192.0.2.0/33"))
+		if err == nil {
+			framework.Failf("Network policy with the ipBlock CIDR 192.0.2.0/33 was accepted")
+		}
+
+		restarts, err := getOvnkubeMasterRestarts(f)
+		framework.ExpectNoError(err, "failed to get the ovnkube-master pods")
+		server := createServerPod(f, f.Namespace.Name, serverName, "", serverLabels)
+		createClientPod(f, f.Namespace.Name, allowedName, "", clientLabels)
+		createClientPod(f, f.Namespace.Name, deniedName, "", nil)
+
+		By(fmt.Sprintf("Creating a policy with the ipBlock CIDR %s next to a valid pod selector rule", hostBitsCIDR))
+		createNetworkPolicy(f, f.Namespace.Name, ipBlockPolicy("host-bits-cidr", hostBitsCIDR))
+
+		By("Verifying the valid rule still applies")
+		expectConnectivity(f.Namespace.Name, allowedName, server.Status.PodIP, netexecPort)
+		expectNoConnectivity(f.Namespace.Name, deniedName, server.Status.PodIP, netexecPort)
+
+		By("Verifying the malformed CIDR was flagged and ovnkube-master survived it")
+		assertMatchRejected(f, server.Spec.NodeName, hostBitsCIDR)
+		assertOvnkubeMasterSurvived(f, restarts)
+	})
+
+	It("Should never let denied traffic through while an isolating policy is deleted and recreated", func() {
+		const (
+			allowedName string = "churn-allowed-client"
+			deniedName  string = "churn-denied-client"
+			rounds             = 10
+		)
+		serverLabels := map[string]string{"app": serverName}
+		clientLabels := map[string]string{"netpol-client": "allowed"}
+
+		server := createServerPod(f, f.Namespace.Name, serverName, "", serverLabels)
+		serverIP := server.Status.PodIP
+		createClientPod(f, f.Namespace.Name, allowedName, "", clientLabels)
+
+		// deleting the only policy selecting a pod legitimately opens it up again, so an
+		// equivalent policy keeps the server isolated while the churned one comes and goes
+		createNetworkPolicy(f, f.Namespace.Name, allowFromPodOnPortPolicy("allow-client-standing", serverLabels, clientLabels, netexecPort))
+		churned := createNetworkPolicy(f, f.Namespace.Name, allowFromPodOnPortPolicy("allow-client-churned", serverLabels, clientLabels, netexecPort))
+		expectConnectivity(f.Namespace.Name, allowedName, serverIP, netexecPort)
+
+		createProbeLoggerPod(f, deniedName, "", net.JoinHostPort(serverIP, strconv.Itoa(netexecPort)))
+		expectNoConnectivity(f.Namespace.Name, deniedName, serverIP, netexecPort)
+
+		By(fmt.Sprintf("Deleting and recreating policy %s %d times while %s keeps probing the server", churned.Name, rounds, deniedName))
+		churned.ResourceVersion = ""
+		records, err := churnPolicyWhileProbing(f, churned, rounds, deniedName)
+		framework.ExpectNoError(err, "failed to churn policy %s", churned.Name)
+		if len(records) == 0 {
+			framework.Failf("Client %s made no request during the churn", deniedName)
+		}
+		for _, record := range records {
+			if record.responder != "" {
+				framework.Failf("Denied client %s was answered by %s at %d during the churn", deniedName, record.responder, record.at)
+			}
+		}
+
+		By("Verifying the allowed client still reaches the server")
+		expectConnectivity(f.Namespace.Name, allowedName, serverIP, netexecPort)
+	})
+
+	It("Should connect and isolate the pods of a burst of namespaces created at once", func() {
+		const namespaces = 10
+
+		By(fmt.Sprintf("Creating %d namespaces with their pods and policies at once", namespaces))
+		start := time.Now()
+		burst := createNamespaceBurst(f, "netpol-burst", namespaces)
+		framework.Logf("Pods of the %d namespaces were running after %v", namespaces, time.Since(start))
+
+		By("Sampling connectivity across the namespaces until the policies converge")
+		var failures []string
+		err := wait.PollImmediate(pokeInterval, 3*time.Minute, func() (bool, error) {
+			failures = sampleNamespaceConnectivity(burst)
+			return len(failures) == 0, nil
+		})
+		framework.ExpectNoError(err, "connectivity of the namespace burst did not converge:\n%s", strings.Join(failures, "\n"))
+		framework.Logf("Connectivity of the %d namespaces converged %v after their creation", namespaces, time.Since(start))
+	})
+
+	It("Should scope the policies of pods with identical labels to their own namespace across many namespaces", func() {
+		const (
+			namespaces = 30
+			pairs      = 20
+		)
+		By(fmt.Sprintf("Creating %d namespaces with identically labelled pods and policies", namespaces))
+		burst := createNamespaceBurst(f, "netpol-scope", namespaces)
+		var failures []string
+		err := wait.PollImmediate(pokeInterval, 3*time.Minute, func() (bool, error) {
+			failures = sampleNamespaceConnectivity(burst)
+			return len(failures) == 0, nil
+		})
+		framework.ExpectNoError(err, "the policies of the namespaces did not converge:\n%s", strings.Join(failures, "\n"))
+
+		By(fmt.Sprintf("Probing %d random pairs of namespaces across their policies", pairs))
+		if failures = sampleCrossNamespaceConnectivity(burst, pairs); len(failures) > 0 {
+			framework.Failf("Policies admitted clients of other namespaces with the same labels:\n%s", strings.Join(failures, "\n"))
+		}
+	})
+})
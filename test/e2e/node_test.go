@@ -0,0 +1,796 @@
+package e2e_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+	e2eservice "k8s.io/kubernetes/test/e2e/framework/service"
+)
+
+// flapNodeInterface brings the interface of the KIND node container down for downTime
+// and then back up.
+func flapNodeInterface(nodeName, iface string, downTime time.Duration) error {
+	if _, err := runCommand("docker", "exec", nodeName, "ip", "link", "set", iface, "down"); err != nil {
+		return err
+	}
+	time.Sleep(downTime)
+	_, err := runCommand("docker", "exec", nodeName, "ip", "link", "set", iface, "up")
+	return err
+}
+
+// getNodeMTU returns the MTU of the interface inside the KIND node container
+func getNodeMTU(nodeName, iface string) (int, error) {
+	out, err := runCommand("docker", "exec", nodeName, "cat", "/sys/class/net/"+iface+"/mtu")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// setNodeMTU sets the MTU of the interface inside the KIND node container
+func setNodeMTU(nodeName, iface string, mtu int) error {
+	_, err := runCommand("docker", "exec", nodeName, "ip", "link", "set", iface, "mtu", strconv.Itoa(mtu))
+	return err
+}
+
+// assertLogicalSwitchExists verifies the node's logical switch is present in the northbound
+// database together with its router and management ports.
+func assertLogicalSwitchExists(f *framework.Framework, nodeName string) {
+	ports, err := runNbctl(f, "lsp-list", nodeName)
+	if err != nil {
+		framework.Failf("Unable to list the ports of the logical switch %s: %v", nodeName, err)
+	}
+	for _, port := range []string{"stor-" + nodeName, "k8s-" + nodeName} {
+		if !strings.Contains(ports, "("+port+")") {
+			framework.Failf("Logical switch %s is missing port %s, found ports:\n%s", nodeName, port, ports)
+		}
+	}
+}
+
+// chassisMatchesNodes compares the southbound chassis and the per node logical switches and
+// gateway routers with the nodes of the cluster, returning an error describing any missing or
+// orphaned object.
+func chassisMatchesNodes(f *framework.Framework) error {
+	nodes, err := f.ClientSet.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	nodeNames := sets.NewString()
+	for _, node := range nodes.Items {
+		nodeNames.Insert(node.Name)
+	}
+
+	chassis, err := runSbctl(f, "--data=bare", "--no-heading", "--columns=hostname", "list", "chassis")
+	if err != nil {
+		return err
+	}
+	switches, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=name", "list", "logical_switch")
+	if err != nil {
+		return err
+	}
+	routers, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=name", "list", "logical_router")
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	compare := func(kind string, owners sets.String) {
+		if missing := nodeNames.Difference(owners); missing.Len() > 0 {
+			problems = append(problems, fmt.Sprintf("nodes without a %s: %v", kind, missing.List()))
+		}
+		if orphaned := owners.Difference(nodeNames); orphaned.Len() > 0 {
+			problems = append(problems, fmt.Sprintf("orphaned %s of nodes: %v", kind, orphaned.List()))
+		}
+	}
+	compare("chassis", sets.NewString(strings.Fields(chassis)...))
+	// a stale chassis left behind by a node that went away and came back shares the hostname of
+	// the current one
+	chassisCount := make(map[string]int)
+	for _, hostname := range strings.Fields(chassis) {
+		chassisCount[hostname]++
+	}
+	for _, hostname := range sets.StringKeySet(chassisCount).List() {
+		if chassisCount[hostname] > 1 {
+			problems = append(problems, fmt.Sprintf("node %s has %d chassis", hostname, chassisCount[hostname]))
+		}
+	}
+	// every node owns a switch named after it plus a join_ and an ext_ switch
+	nodeSwitches := sets.NewString()
+	for _, name := range strings.Fields(switches) {
+		nodeSwitches.Insert(strings.TrimPrefix(strings.TrimPrefix(name, "join_"), "ext_"))
+	}
+	compare("logical switch", nodeSwitches)
+	gatewayRouters := sets.NewString()
+	for _, name := range strings.Fields(routers) {
+		if strings.HasPrefix(name, "GR_") {
+			gatewayRouters.Insert(strings.TrimPrefix(name, "GR_"))
+		}
+	}
+	compare("gateway router", gatewayRouters)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("OVN topology does not match the cluster nodes %v: %s", nodeNames.List(), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// assertChassisMatchesNodes waits for the OVN chassis, node switches and gateway routers to
+// match the nodes of the cluster, failing the test if they do not converge.
+func assertChassisMatchesNodes(f *framework.Framework) {
+	var lastErr error
+	err := wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+		lastErr = chassisMatchesNodes(f)
+		return lastErr == nil, nil
+	})
+	framework.ExpectNoError(err, "%v", lastErr)
+}
+
+// assertNodeChassisMapping waits for the node to be bound to the single chassis of the given
+// system-id, both in the southbound chassis table and on its gateway router, failing the test if
+// it does not converge.
+func assertNodeChassisMapping(f *framework.Framework, nodeName, systemID string) {
+	var chassis, gatewayChassis string
+	err := wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+		out, err := runSbctl(f, "--data=bare", "--no-heading", "--columns=name", "find", "chassis", "hostname="+nodeName)
+		if err != nil {
+			return false, nil
+		}
+		chassis = strings.Join(strings.Fields(out), " ")
+		out, err = runNbctl(f, "--if-exists", "get", "logical_router", "GR_"+nodeName, "options:chassis")
+		if err != nil {
+			return false, nil
+		}
+		gatewayChassis = strings.Trim(strings.TrimSpace(out), `"`)
+		return chassis == systemID && gatewayChassis == systemID, nil
+	})
+	framework.ExpectNoError(err, "node %s is bound to chassis %q with gateway chassis %q instead of the single chassis %s",
+		nodeName, chassis, gatewayChassis, systemID)
+}
+
+// path the ovs-daemons container persists the system-id of the node to; ovs-ctl reads it back
+// on every start, so it survives the restart of ovnkube-node
+const systemIDConfPath = "/etc/openvswitch/system-id.conf"
+
+// getNodeSystemID returns the OVS system-id, and so the chassis name, of the node
+func getNodeSystemID(f *framework.Framework, nodeName string) (string, error) {
+	ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return "", err
+	}
+	systemID, err := framework.RunKubectl("exec", ovnkubeNode.Name, "--namespace="+ovnNamespace, "--container=ovs-daemons", "--",
+		"ovs-vsctl", "get", "Open_vSwitch", ".", "external_ids:system-id")
+	if err != nil {
+		return "", fmt.Errorf("failed to get the system-id of node %s: %v", nodeName, err)
+	}
+	return strings.Trim(strings.TrimSpace(systemID), `"`), nil
+}
+
+// setNodeSystemID changes the OVS system-id of the node, both in the running database and in the
+// file ovs-ctl restores it from, so that the new identity outlives a restart of ovnkube-node
+func setNodeSystemID(f *framework.Framework, nodeName, systemID string) error {
+	ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return err
+	}
+	_, err = framework.RunKubectl("exec", ovnkubeNode.Name, "--namespace="+ovnNamespace, "--container=ovs-daemons", "--",
+		"sh", "-c", fmt.Sprintf("echo %[1]s > %[2]s && ovs-vsctl set Open_vSwitch . external_ids:system-id=%[1]s", systemID, systemIDConfPath))
+	if err != nil {
+		return fmt.Errorf("failed to set the system-id of node %s to %s: %v", nodeName, systemID, err)
+	}
+	return nil
+}
+
+// restartOvnkubeNodePod deletes the ovnkube-node pod of the node and waits for its replacement to run
+func restartOvnkubeNodePod(f *framework.Framework, nodeName string) error {
+	ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return err
+	}
+	if _, err := framework.RunKubectl("delete", "pod", ovnkubeNode.Name, "--namespace="+ovnNamespace); err != nil {
+		return err
+	}
+	return wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+		pod, err := getOvnkubeNodePod(f, nodeName)
+		return err == nil && pod.UID != ovnkubeNode.UID, nil
+	})
+}
+
+// errNoPodOnNode is returned by getOvnkubeNodePod for a node no ovnkube-node pod is scheduled to
+var errNoPodOnNode = errors.New("no ovnkube-node pod on the node")
+
+// getOvnNodePodOnNode returns the name of the running ovnkube-node pod of the node, or an error
+// wrapping errNoPodOnNode when no ovnkube-node pod is scheduled to it
+func getOvnNodePodOnNode(f *framework.Framework, nodeName string) (string, error) {
+	pod, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return "", err
+	}
+	return pod.Name, nil
+}
+
+// getOvnkubeNodePod returns the running ovnkube-node pod of the node, or an error wrapping
+// errNoPodOnNode when no ovnkube-node pod is scheduled to it
+func getOvnkubeNodePod(f *framework.Framework, nodeName string) (*v1.Pod, error) {
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{
+		LabelSelector: "name=ovnkube-node",
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoPodOnNode, nodeName)
+	}
+	for i := range podList.Items {
+		if pod := &podList.Items[i]; pod.Status.Phase == v1.PodRunning && pod.DeletionTimestamp == nil {
+			return pod, nil
+		}
+	}
+	return nil, fmt.Errorf("no running ovnkube-node pod found on node %s", nodeName)
+}
+
+// path the ovnkube-node container installs the CNI plugin to on the node
+const cniBinaryPath = "/opt/cni/bin/ovn-k8s-cni-overlay"
+
+// getNodeCNIBinary returns the checksum and modification time of the CNI plugin installed on the KIND node
+func getNodeCNIBinary(nodeName string) (string, string, error) {
+	out, err := runCommand("docker", "exec", nodeName, "sh", "-c",
+		fmt.Sprintf("sha256sum %[1]s | cut -d' ' -f1; stat -c %%Y %[1]s", cniBinaryPath))
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected checksum and modification time of %s on node %s: %q", cniBinaryPath, nodeName, out)
+	}
+	return fields[0], fields[1], nil
+}
+
+// waitForNodeReadiness waits for the Ready condition of the node to reach the wanted state
+func waitForNodeReadiness(f *framework.Framework, nodeName string, ready bool, timeout time.Duration) error {
+	return wait.PollImmediate(pokeInterval, timeout, func() (bool, error) {
+		node, err := f.ClientSet.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return e2enode.IsNodeReady(node) == ready, nil
+	})
+}
+
+// setNodeNotReady stops the kubelet of the KIND node until the node is reported NotReady, then
+// starts it again and waits for the node to be Ready.
+func setNodeNotReady(f *framework.Framework, nodeName string) error {
+	if _, err := runCommand("docker", "exec", nodeName, "systemctl", "stop", "kubelet"); err != nil {
+		return err
+	}
+	notReadyErr := waitForNodeReadiness(f, nodeName, false, 2*time.Minute)
+	if _, err := runCommand("docker", "exec", nodeName, "systemctl", "start", "kubelet"); err != nil {
+		return err
+	}
+	if notReadyErr != nil {
+		return fmt.Errorf("node %s was not reported NotReady: %v", nodeName, notReadyErr)
+	}
+	return waitForNodeReadiness(f, nodeName, true, 2*time.Minute)
+}
+
+// detectGatewayMode returns the gateway mode the ovnkube-node DaemonSet configures, shared when unset
+func detectGatewayMode(f *framework.Framework) (string, error) {
+	ds, err := f.ClientSet.AppsV1().DaemonSets(ovnNamespace).Get("ovnkube-node", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, container := range ds.Spec.Template.Spec.Containers {
+		if container.Name != "ovnkube-node" {
+			continue
+		}
+		for _, env := range container.Env {
+			if env.Name == "OVN_GATEWAY_MODE" && env.Value != "" {
+				return env.Value, nil
+			}
+		}
+	}
+	return "shared", nil
+}
+
+// l3GatewayConfigAnnotation holds the gateway configuration ovnkube-node started with on a node
+const l3GatewayConfigAnnotation = "k8s.ovn.org/l3-gateway-config"
+
+// getNodeGatewayMode returns the gateway mode the ovnkube-node of the node runs with, as published
+// in the gateway configuration annotation of the node, and checks the local gateway bridge exists
+// on the node in local mode.
+func getNodeGatewayMode(f *framework.Framework, nodeName string) (string, error) {
+	node, err := f.ClientSet.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	var gatewayConfig map[string]struct {
+		Mode string `json:"mode"`
+	}
+	annotation := node.Annotations[l3GatewayConfigAnnotation]
+	if err := json.Unmarshal([]byte(annotation), &gatewayConfig); err != nil {
+		return "", fmt.Errorf("failed to parse the %s annotation %q of node %s: %v", l3GatewayConfigAnnotation, annotation, nodeName, err)
+	}
+	mode := gatewayConfig["default"].Mode
+	if mode != "local" {
+		return mode, nil
+	}
+	ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := framework.RunKubectl("exec", ovnkubeNode.Name, "--namespace="+ovnNamespace, "--container=ovnkube-node", "--",
+		"ovs-vsctl", "br-exists", "br-local"); err != nil {
+		return "", fmt.Errorf("node %s runs in local gateway mode without the br-local bridge: %v", nodeName, err)
+	}
+	return mode, nil
+}
+
+// setGatewayMode reconfigures the gateway mode of the ovnkube-node DaemonSet and waits for the
+// rollout restarting every ovnkube-node pod with it to complete.
+func setGatewayMode(mode string) error {
+	if _, err := framework.RunKubectl("set", "env", "daemonset/ovnkube-node", "--namespace="+ovnNamespace,
+		"--containers=ovnkube-node", "OVN_GATEWAY_MODE="+mode); err != nil {
+		return err
+	}
+	_, err := framework.RunKubectl("rollout", "status", "daemonset", "ovnkube-node", "--namespace="+ovnNamespace, "--timeout=5m")
+	return err
+}
+
+// gatewayRouterExists reports whether the gateway router of the node is in the northbound database
+func gatewayRouterExists(f *framework.Framework, nodeName string) (bool, error) {
+	name, err := runNbctl(f, "--if-exists", "get", "logical_router", "GR_"+nodeName, "name")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(name) != "", nil
+}
+
+// recreateGatewayRouter deletes the gateway router of the node from the northbound database and
+// restarts the control plane of the node for ovnkube-master to recreate it, waiting for the router
+// to reappear.
+func recreateGatewayRouter(f *framework.Framework, nodeName string) error {
+	if _, err := runNbctl(f, "lr-del", "GR_"+nodeName); err != nil {
+		return fmt.Errorf("failed to delete the gateway router of node %s: %v", nodeName, err)
+	}
+	if _, err := killMasterAndNodePods(f, nodeName); err != nil {
+		return err
+	}
+	return wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+		exists, err := gatewayRouterExists(f, nodeName)
+		return err == nil && exists, nil
+	})
+}
+
+// pokeNodePort requests the netexec hostname endpoint of the NodePort on nodeIP from the host
+// network of the KIND node fromNode, returning the name of the pod that answered.
+func pokeNodePort(fromNode, nodeIP string, nodePort int) (string, error) {
+	url := fmt.Sprintf("http://%s/hostname", net.JoinHostPort(nodeIP, strconv.Itoa(nodePort)))
+	return runCommand("docker", "exec", fromNode, "curl", "--connect-timeout", "2", "--max-time", "5", "-s", "-f", url)
+}
+
+// Validate the datapath recovers from disruptions of the nodes it runs on
+var _ = Describe("e2e node disruption", func() {
+	const (
+		svcname         string = "node-disruption"
+		uplink          string = "eth0"
+		recoveryTimeout        = 60 * time.Second
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should restore connectivity between pods on separate nodes after the uplink of a node flaps", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		const downTime = 10 * time.Second
+		server := createServerPod(f, f.Namespace.Name, "flap-server", serverNode, nil)
+		createClientPod(f, f.Namespace.Name, "flap-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "flap-client", server.Status.PodIP, netexecPort)
+
+		By(fmt.Sprintf("Probing the server from node %s continuously", clientNode))
+		createProbeLoggerPod(f, "flap-probe", clientNode, net.JoinHostPort(server.Status.PodIP, strconv.Itoa(netexecPort)))
+		flapStart := time.Now().Unix()
+
+		By(fmt.Sprintf("Flapping the uplink %s of node %s", uplink, serverNode))
+		// make sure the uplink is restored even if the test fails half way
+		defer runCommand("docker", "exec", serverNode, "ip", "link", "set", uplink, "up")
+		framework.ExpectNoError(flapNodeInterface(serverNode, uplink, downTime))
+
+		By("Verifying connectivity recovers within the bounded recovery window")
+		start := time.Now()
+		err = wait.PollImmediate(time.Second, recoveryTimeout, func() (bool, error) {
+			_, err := pokeHTTP(f.Namespace.Name, "flap-client", server.Status.PodIP, netexecPort)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "connectivity from node %s to node %s did not recover within %v", clientNode, serverNode, recoveryTimeout)
+		framework.Logf("Connectivity recovered %v after the uplink of %s came back up", time.Since(start), serverNode)
+
+		By("Verifying the continuous probes only failed for a bounded time around the flap")
+		// let the probes made after the recovery make it into the log
+		time.Sleep(5 * time.Second)
+		records, err := getProbeRecords(f, "flap-probe")
+		framework.ExpectNoError(err, "failed to get the requests of the probe pod")
+		var during []probeRecord
+		for _, record := range records {
+			if record.at >= flapStart {
+				during = append(during, record)
+			}
+		}
+		if len(during) == 0 {
+			framework.Failf("The probe pod logged no request during the flap")
+		}
+		if last := during[len(during)-1]; last.responder != server.Name {
+			framework.Failf("The last request of the probe pod at %d was answered by %q instead of %s", last.at, last.responder, server.Name)
+		}
+		outage := time.Duration(longestProbeOutage(during)) * time.Second
+		if outage > downTime+recoveryTimeout {
+			framework.Failf("Continuous probes failed for %v, longer than the %v flap and the %v recovery window", outage, downTime, recoveryTimeout)
+		}
+		framework.Logf("Continuous probes failed for %v across the flap", outage)
+	})
+
+	It("Should keep the logical switch of a node drained of all pods usable", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, drainedNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		By(fmt.Sprintf("Draining node %s of every pod not owned by a DaemonSet", drainedNode))
+		defer framework.RunKubectl("uncordon", drainedNode)
+		framework.RunKubectlOrDie("drain", drainedNode, "--ignore-daemonsets", "--delete-local-data", "--force", "--timeout=5m")
+
+		By(fmt.Sprintf("Verifying the logical switch of %s survived the drain", drainedNode))
+		assertLogicalSwitchExists(f, drainedNode)
+
+		By(fmt.Sprintf("Scheduling a pod back onto %s and verifying it is reachable", drainedNode))
+		framework.RunKubectlOrDie("uncordon", drainedNode)
+		server := createServerPod(f, f.Namespace.Name, "drained-node-server", drainedNode, nil)
+		createClientPod(f, f.Namespace.Name, "drained-node-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "drained-node-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should converge the OVN topology when a node quickly leaves and rejoins the cluster", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		flappedNode := nodes.Items[0].Name
+
+		By("Verifying the OVN topology matches the nodes before the flap")
+		assertChassisMatchesNodes(f)
+
+		By(fmt.Sprintf("Deleting node %s and restarting its kubelet so that it registers again", flappedNode))
+		framework.RunKubectlOrDie("delete", "node", flappedNode)
+		_, err = runCommand("docker", "exec", flappedNode, "systemctl", "restart", "kubelet")
+		framework.ExpectNoError(err, "failed to restart the kubelet of node %s", flappedNode)
+		err = waitForNodeReadiness(f, flappedNode, true, 2*time.Minute)
+		framework.ExpectNoError(err, "node %s did not become ready again", flappedNode)
+
+		By("Verifying the OVN topology converges without orphaned objects")
+		assertChassisMatchesNodes(f)
+		server := createServerPod(f, f.Namespace.Name, "rejoined-node-server", flappedNode, nil)
+		createClientPod(f, f.Namespace.Name, "rejoined-node-client", "", nil)
+		expectConnectivity(f.Namespace.Name, "rejoined-node-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should reconcile the OVN topology when the chassis identity of a node changes", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+		assertChassisMatchesNodes(f)
+
+		oldID, err := getNodeSystemID(f, nodeName)
+		framework.ExpectNoError(err)
+		newID := string(uuid.NewUUID())
+		By(fmt.Sprintf("Changing the chassis identity of node %s to %s", nodeName, newID))
+		framework.ExpectNoError(setNodeSystemID(f, nodeName, newID))
+		defer func() {
+			if err := setNodeSystemID(f, nodeName, oldID); err != nil {
+				framework.Logf("Failed to restore the system-id of node %s: %v", nodeName, err)
+			}
+			if err := restartOvnkubeNodePod(f, nodeName); err != nil {
+				framework.Logf("Failed to restart ovnkube-node on node %s: %v", nodeName, err)
+			}
+		}()
+
+		// ovn-controller follows the new system-id on its own, but ovnkube-node only publishes the
+		// chassis of the gateway router in the node annotations when it starts
+		By(fmt.Sprintf("Restarting ovnkube-node on node %s so that its gateway router follows the new identity", nodeName))
+		err = restartOvnkubeNodePod(f, nodeName)
+		framework.ExpectNoError(err, "ovnkube-node was not recreated on node %s", nodeName)
+
+		By("Verifying the topology converges on the new identity without duplicates")
+		assertChassisMatchesNodes(f)
+		assertNodeChassisMapping(f, nodeName, newID)
+
+		server := createServerPod(f, f.Namespace.Name, "reidentified-node-server", nodeName, nil)
+		createClientPod(f, f.Namespace.Name, "reidentified-node-client", "", nil)
+		expectConnectivity(f.Namespace.Name, "reidentified-node-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should network new pods with the reinstalled CNI plugin after an ovnkube-node rollout without disturbing existing pods", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+
+		server := createServerPod(f, f.Namespace.Name, "cni-upgrade-server", nodeName, nil)
+		createClientPod(f, f.Namespace.Name, "cni-upgrade-client", "", nil)
+		expectConnectivity(f.Namespace.Name, "cni-upgrade-client", server.Status.PodIP, netexecPort)
+		_, installedAt, err := getNodeCNIBinary(nodeName)
+		framework.ExpectNoError(err, "failed to inspect the CNI plugin of node %s", nodeName)
+
+		// every ovnkube-node pod reinstalls the CNI plugin shipped in its image when it starts, so
+		// a rollout replaces the plugin of each node the way an image upgrade does
+		By("Rolling the ovnkube-node DaemonSet out again")
+		patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"e2e.ovn.org/restarted-at":"%s"}}}}}`, time.Now().Format(time.RFC3339))
+		framework.RunKubectlOrDie("patch", "daemonset", "ovnkube-node", "--namespace="+ovnNamespace, "-p", patch)
+		framework.RunKubectlOrDie("rollout", "status", "daemonset", "ovnkube-node", "--namespace="+ovnNamespace, "--timeout=5m")
+
+		By(fmt.Sprintf("Verifying node %s runs the CNI plugin of the new ovnkube-node pod", nodeName))
+		ovnkubeNode, err := getOvnkubeNodePod(f, nodeName)
+		framework.ExpectNoError(err)
+		shipped, err := framework.RunKubectl("exec", ovnkubeNode.Name, "--namespace="+ovnNamespace, "--container=ovnkube-node", "--",
+			"sh", "-c", "sha256sum /usr/libexec/cni/ovn-k8s-cni-overlay | cut -d' ' -f1")
+		framework.ExpectNoError(err, "failed to checksum the CNI plugin shipped in %s", ovnkubeNode.Name)
+		installed, reinstalledAt, err := getNodeCNIBinary(nodeName)
+		framework.ExpectNoError(err, "failed to inspect the CNI plugin of node %s", nodeName)
+		if installed != strings.TrimSpace(shipped) || reinstalledAt == installedAt {
+			framework.Failf("Node %s runs CNI plugin %s installed at %s, expected the plugin %s of %s to be reinstalled",
+				nodeName, installed, reinstalledAt, strings.TrimSpace(shipped), ovnkubeNode.Name)
+		}
+
+		By("Verifying the existing pod was undisturbed and a new pod gets connectivity")
+		current, err := f.PodClient().Get(server.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", server.Name)
+		if current.UID != server.UID || current.Status.PodIP != server.Status.PodIP {
+			framework.Failf("Pod %s was recreated or readdressed by the rollout", server.Name)
+		}
+		expectConnectivity(f.Namespace.Name, "cni-upgrade-client", server.Status.PodIP, netexecPort)
+		newServer := createServerPod(f, f.Namespace.Name, "cni-upgrade-new-server", nodeName, nil)
+		expectConnectivity(f.Namespace.Name, "cni-upgrade-client", newServer.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep the ports and connectivity of the pods of a node that is briefly NotReady", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, flakyNode := nodes.Items[0].Name, nodes.Items[1].Name
+		server := createServerPod(f, f.Namespace.Name, "not-ready-server", flakyNode, nil)
+		createClientPod(f, f.Namespace.Name, "not-ready-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "not-ready-client", server.Status.PodIP, netexecPort)
+		portName := f.Namespace.Name + "_" + server.Name
+
+		By(fmt.Sprintf("Making node %s NotReady for a while", flakyNode))
+		defer runCommand("docker", "exec", flakyNode, "systemctl", "start", "kubelet")
+		framework.ExpectNoError(setNodeNotReady(f, flakyNode))
+
+		By(fmt.Sprintf("Verifying the pods of node %s kept their ports and connectivity", flakyNode))
+		exists, err := logicalPortExists(f, portName)
+		framework.ExpectNoError(err, "failed to look up logical port %s", portName)
+		if !exists {
+			framework.Failf("Logical port %s was removed while node %s was NotReady", portName, flakyNode)
+		}
+		current, err := f.PodClient().Get(server.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", server.Name)
+		if current.UID != server.UID {
+			framework.Failf("Pod %s was recreated while node %s was NotReady", server.Name, flakyNode)
+		}
+		expectConnectivity(f.Namespace.Name, "not-ready-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should map the chassis of a node whose hostname differs from its node name to the node", func() {
+		const (
+			serverName string = "renamed-host-server"
+			clientName string = "renamed-host-client"
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+		systemID, err := getNodeSystemID(f, nodeName)
+		framework.ExpectNoError(err)
+
+		hostname := nodeName + "-renamed"
+		By(fmt.Sprintf("Changing the hostname of node %s to %s", nodeName, hostname))
+		_, err = runCommand("docker", "exec", nodeName, "hostname", hostname)
+		framework.ExpectNoError(err, "failed to change the hostname of node %s", nodeName)
+		defer func() {
+			if _, err := runCommand("docker", "exec", nodeName, "hostname", nodeName); err != nil {
+				framework.Logf("Failed to restore the hostname of node %s: %v", nodeName, err)
+			}
+			if err := restartOvnkubeNodePod(f, nodeName); err != nil {
+				framework.Logf("Failed to restart ovnkube-node on node %s: %v", nodeName, err)
+			}
+		}()
+
+		By(fmt.Sprintf("Restarting ovnkube-node on node %s under the new hostname", nodeName))
+		err = restartOvnkubeNodePod(f, nodeName)
+		framework.ExpectNoError(err, "ovnkube-node was not recreated on node %s", nodeName)
+
+		By(fmt.Sprintf("Verifying the chassis %s is still mapped to node %s", systemID, nodeName))
+		assertChassisMatchesNodes(f)
+		assertNodeChassisMapping(f, nodeName, systemID)
+
+		server := createServerPod(f, f.Namespace.Name, serverName, nodeName, nil)
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, server.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep pod egress and services working after the gateway mode is switched", func() {
+		const (
+			probeClientName string = "gw-mode-probe-client"
+			clientName      string = "gw-mode-client"
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		var egressTarget string
+		for _, address := range nodes.Items[1].Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				egressTarget = address.Address
+			}
+		}
+		mode, err := detectGatewayMode(f)
+		framework.ExpectNoError(err, "failed to detect the gateway mode")
+		newMode := "local"
+		if mode == "local" {
+			newMode = "shared"
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "gw-mode-svc")
+		_, err = jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(2)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to run the service backends")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		createClientPod(f, f.Namespace.Name, clientName, nodes.Items[0].Name, nil)
+		createProbeLoggerPod(f, probeClientName, nodes.Items[0].Name, net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort)))
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+
+		By(fmt.Sprintf("Switching the gateway mode from %s to %s", mode, newMode))
+		defer func() {
+			if err := setGatewayMode(mode); err != nil {
+				framework.Logf("Failed to restore the gateway mode %s: %v", mode, err)
+			}
+		}()
+		start := time.Now().Unix()
+		framework.ExpectNoError(setGatewayMode(newMode), "failed to switch the gateway mode to %s", newMode)
+		for _, node := range nodes.Items {
+			var detected string
+			var lastErr error
+			err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+				detected, lastErr = getNodeGatewayMode(f, node.Name)
+				return lastErr == nil && detected == newMode, nil
+			})
+			framework.ExpectNoError(err, "node %s runs in gateway mode %q after switching to %s: %v", node.Name, detected, newMode, lastErr)
+		}
+
+		By("Verifying service connectivity and pod egress after reconvergence")
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			_, err := framework.RunKubectl("exec", clientName, "--namespace="+f.Namespace.Name, "--", "ping", "-c", "1", "-W", "2", egressTarget)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "pod %s did not reach %s after the gateway mode switch", clientName, egressTarget)
+
+		records, err := getProbeRecords(f, probeClientName)
+		framework.ExpectNoError(err, "failed to get the logs of the client")
+		var during []probeRecord
+		failed := 0
+		for _, record := range records {
+			if record.at >= start {
+				during = append(during, record)
+				if record.responder == "" {
+					failed++
+				}
+			}
+		}
+		framework.Logf("Service %s missed %d requests, one every 200ms, during the switch to %s gateway mode", svc.Spec.ClusterIP, failed, newMode)
+		if len(during) == 0 || during[len(during)-1].responder == "" {
+			framework.Failf("Service %s did not answer the requests of %s after the switch to %s gateway mode", svc.Spec.ClusterIP, probeClientName, newMode)
+		}
+		if outage := time.Duration(longestProbeOutage(during)) * time.Second; outage > convergeTimeout {
+			framework.Failf("Service %s was unreachable for %v during the switch to %s gateway mode, longer than %v", svc.Spec.ClusterIP, outage, newMode, convergeTimeout)
+		}
+	})
+
+	It("Should restore pod egress and NodePort connectivity of a node after its gateway router is recreated", func() {
+		const (
+			serverName string = "gr-recreate-server"
+			clientName string = "gr-recreate-client"
+			// how long the node may take to forward again once its gateway router is back
+			restoreTimeout = 2 * time.Minute
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		gwNode, otherNode := nodes.Items[0], nodes.Items[1]
+		var gwNodeIP, egressTarget string
+		for _, address := range gwNode.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				gwNodeIP = address.Address
+			}
+		}
+		for _, address := range otherNode.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				egressTarget = address.Address
+			}
+		}
+		serverLabels := map[string]string{"app": serverName}
+		createServerPod(f, f.Namespace.Name, serverName, gwNode.Name, serverLabels)
+		createClientPod(f, f.Namespace.Name, clientName, gwNode.Name, nil)
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: serverName,
+			},
+			Spec: v1.ServiceSpec{
+				Type:     v1.ServiceTypeNodePort,
+				Selector: serverLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the NodePort service")
+		nodePort := int(svc.Spec.Ports[0].NodePort)
+
+		checkGatewayPaths := func(timeout time.Duration) {
+			err := wait.PollImmediate(pokeInterval, timeout, func() (bool, error) {
+				_, err := framework.RunKubectl("exec", clientName, "--namespace="+f.Namespace.Name, "--", "ping", "-c", "1", "-W", "2", egressTarget)
+				return err == nil, nil
+			})
+			framework.ExpectNoError(err, "pod %s on node %s did not reach %s", clientName, gwNode.Name, egressTarget)
+			var answer string
+			err = wait.PollImmediate(pokeInterval, timeout, func() (bool, error) {
+				answer, err = pokeNodePort(otherNode.Name, gwNodeIP, nodePort)
+				return err == nil, nil
+			})
+			framework.ExpectNoError(err, "node %s did not reach NodePort %s:%d", otherNode.Name, gwNodeIP, nodePort)
+			if answer = strings.TrimSpace(answer); answer != serverName {
+				framework.Failf("NodePort %s:%d was answered by %q instead of %s", gwNodeIP, nodePort, answer, serverName)
+			}
+		}
+		checkGatewayPaths(convergeTimeout)
+
+		By(fmt.Sprintf("Deleting the gateway router of node %s and having the control plane recreate it", gwNode.Name))
+		framework.ExpectNoError(recreateGatewayRouter(f, gwNode.Name), "the gateway router of node %s was not recreated", gwNode.Name)
+
+		By(fmt.Sprintf("Verifying egress and the NodePort of node %s are restored within %v", gwNode.Name, restoreTimeout))
+		checkGatewayPaths(restoreTimeout)
+	})
+})
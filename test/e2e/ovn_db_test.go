@@ -0,0 +1,430 @@
+package e2e_test
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+)
+
+// ovnDBPaths returns a shell snippet locating the socket and schema of the "nb" or "sb" database,
+// whose directories depend on the OVN version the images were built with.
+func ovnDBPaths(db string) string {
+	return fmt.Sprintf(`sock=/var/run/ovn/ovn%[1]s_db.sock; [ -S $sock ] || sock=/var/run/openvswitch/ovn%[1]s_db.sock; `+
+		`schema=/usr/share/ovn/ovn-%[1]s.ovsschema; [ -f $schema ] || schema=/usr/share/openvswitch/ovn-%[1]s.ovsschema; `, db)
+}
+
+// runInOvnDBContainer runs the shell script in the nb-ovsdb or sb-ovsdb container of an
+// ovnkube-db pod, with $sock and $schema set to the socket and schema of the "nb" or "sb" database.
+func runInOvnDBContainer(f *framework.Framework, db, script string) (string, error) {
+	dbPod, err := getOvnDBPodName(f)
+	if err != nil {
+		return "", err
+	}
+	out, err := framework.RunKubectl("exec", dbPod, "--namespace="+ovnNamespace, "--container="+db+"-ovsdb", "--",
+		"sh", "-c", ovnDBPaths(db)+script)
+	return strings.TrimSpace(out), err
+}
+
+// ovnControllersConnected returns an error naming every ovn-controller not connected to the
+// southbound database.
+func ovnControllersConnected(f *framework.Framework) error {
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-node"})
+	if err != nil {
+		return err
+	}
+	var disconnected []string
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			disconnected = append(disconnected, fmt.Sprintf("%s (%s)", pod.Spec.NodeName, pod.Status.Phase))
+			continue
+		}
+		out, err := framework.RunKubectl("exec", pod.Name, "--namespace="+ovnNamespace, "--container=ovn-controller", "--",
+			"ovn-appctl", "-t", "ovn-controller", "connection-status")
+		if status := strings.TrimSpace(out); err != nil || status != "connected" {
+			disconnected = append(disconnected, fmt.Sprintf("%s (%q %v)", pod.Spec.NodeName, status, err))
+		}
+	}
+	if len(disconnected) > 0 {
+		return fmt.Errorf("ovn-controller not connected to the southbound database on %s", strings.Join(disconnected, ", "))
+	}
+	return nil
+}
+
+// southbound database port ovn-controller connects to
+const sbDBPort = "6642"
+
+// setSbDBBlocked adds or removes an iptables rule in the KIND node container dropping the
+// connections of the node to the southbound database.
+func setSbDBBlocked(nodeName string, blocked bool) error {
+	action := "-D"
+	if blocked {
+		action = "-I"
+	}
+	_, err := runCommand("docker", "exec", nodeName, "iptables", action, "OUTPUT",
+		"-p", "tcp", "--dport", sbDBPort, "-j", "DROP")
+	return err
+}
+
+// northdRestartCount returns the restart count of the ovn-northd container of the pod
+func northdRestartCount(pod *v1.Pod) int32 {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "ovn-northd" {
+			return status.RestartCount
+		}
+	}
+	return -1
+}
+
+// stopNorthd makes ovn-northd exit in every ovnkube-master pod, which the container notices
+// within its health check interval, and returns the pods as they were before.
+func stopNorthd(f *framework.Framework) ([]v1.Pod, error) {
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-master"})
+	if err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, fmt.Errorf("no ovnkube-master pod found in namespace %s", ovnNamespace)
+	}
+	for _, pod := range podList.Items {
+		if _, err := framework.RunKubectl("exec", pod.Name, "--namespace="+ovnNamespace, "--container=ovn-northd", "--",
+			"ovn-appctl", "-t", "ovn-northd", "exit"); err != nil {
+			return nil, err
+		}
+	}
+	return podList.Items, nil
+}
+
+// waitForNorthdRestarted waits for the ovn-northd container of each of the pods to have been
+// restarted and to be ready again.
+func waitForNorthdRestarted(f *framework.Framework, pods []v1.Pod, timeout time.Duration) error {
+	return wait.PollImmediate(pokeInterval, timeout, func() (bool, error) {
+		for _, old := range pods {
+			pod, err := f.ClientSet.CoreV1().Pods(ovnNamespace).Get(old.Name, metav1.GetOptions{})
+			if err != nil || northdRestartCount(pod) <= northdRestartCount(&old) {
+				return false, nil
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == "ovn-northd" && !status.Ready {
+					return false, nil
+				}
+			}
+		}
+		return true, nil
+	})
+}
+
+// backupOvnDB snapshots the "nb" or "sb" database to the file inside its container
+func backupOvnDB(f *framework.Framework, db, file string) error {
+	out, err := runInOvnDBContainer(f, db, fmt.Sprintf(`ovsdb-client backup unix:$sock > %s`, file))
+	if err != nil {
+		return fmt.Errorf("failed to back the %s database up: %v (%s)", db, err, out)
+	}
+	return nil
+}
+
+// restoreOvnDB replaces the contents of the "nb" or "sb" database with the snapshot in the file
+// inside its container.
+func restoreOvnDB(f *framework.Framework, db, file string) error {
+	out, err := runInOvnDBContainer(f, db, fmt.Sprintf(`ovsdb-client restore unix:$sock < %s`, file))
+	if err != nil {
+		return fmt.Errorf("failed to restore the %s database: %v (%s)", db, err, out)
+	}
+	return nil
+}
+
+// ovnDBNames maps the "nb" and "sb" databases to their schema names
+var ovnDBNames = map[string]string{"nb": "OVN_Northbound", "sb": "OVN_Southbound"}
+
+// getRaftStatus returns the raft cluster status of the "nb" or "sb" database as seen by the member
+// running in the ovnkube-db pod
+func getRaftStatus(podName, db string) (string, error) {
+	script := fmt.Sprintf(`ctl=/var/run/ovn/ovn%[1]s_db.ctl; [ -S $ctl ] || ctl=/var/run/openvswitch/ovn%[1]s_db.ctl; `+
+		`ovs-appctl -t $ctl cluster/status %[2]s`, db, ovnDBNames[db])
+	return framework.RunKubectl("exec", podName, "--namespace="+ovnNamespace, "--container="+db+"-ovsdb", "--",
+		"sh", "-c", script)
+}
+
+// checkRaftQuorum returns an error unless every one of the pods is a member of the raft cluster of
+// the "nb" or "sb" database and exactly one of them is its leader.
+func checkRaftQuorum(pods []v1.Pod, db string) error {
+	leaders := 0
+	for _, pod := range pods {
+		status, err := getRaftStatus(pod.Name, db)
+		if err != nil {
+			return fmt.Errorf("failed to get the %s raft status from %s: %v", db, pod.Name, err)
+		}
+		if !strings.Contains(status, "Status: cluster member") {
+			return fmt.Errorf("%s is not a member of the %s raft cluster:\n%s", pod.Name, db, status)
+		}
+		if strings.Contains(status, "Role: leader") {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		return fmt.Errorf("the %s raft cluster has %d leaders", db, leaders)
+	}
+	return nil
+}
+
+// restartOvnDBMembersWhileProbing deletes the ovnkube-db pods one at a time, each time waiting for
+// the pod to be recreated and both raft clusters to regain their quorum, while the probe logger
+// pod keeps requesting its target. It returns the requests logged during the restarts.
+func restartOvnDBMembersWhileProbing(f *framework.Framework, pods []v1.Pod, probePodName string) ([]probeRecord, error) {
+	podClient := f.ClientSet.CoreV1().Pods(ovnNamespace)
+	start := time.Now().Unix()
+	for _, old := range pods {
+		if err := podClient.Delete(old.Name, &metav1.DeleteOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %v", old.Name, err)
+		}
+		err := wait.PollImmediate(pokeInterval, 5*time.Minute, func() (bool, error) {
+			pod, err := podClient.Get(old.Name, metav1.GetOptions{})
+			if err != nil || pod.UID == old.UID || pod.Status.Phase != v1.PodRunning {
+				return false, nil
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if !status.Ready {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s was not recreated: %v", old.Name, err)
+		}
+		for _, db := range []string{"nb", "sb"} {
+			var lastErr error
+			err := wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+				lastErr = checkRaftQuorum(pods, db)
+				return lastErr == nil, nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("quorum lost after restarting %s: %v", old.Name, lastErr)
+			}
+		}
+	}
+	records, err := getProbeRecords(f, probePodName)
+	if err != nil {
+		return nil, err
+	}
+	var during []probeRecord
+	for _, record := range records {
+		if record.at >= start {
+			during = append(during, record)
+		}
+	}
+	return during, nil
+}
+
+// Validate the datapath survives disruptions of the OVN databases
+var _ = Describe("e2e OVN database disruption", func() {
+	const (
+		svcname string = "ovn-db-disruption"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should keep connectivity and reconnect ovn-controller across a southbound database schema conversion", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		if _, err := runInOvnDBContainer(f, "sb", `[ -S $sock ] && [ -f $schema ]`); err != nil {
+			framework.Skipf("Southbound database socket or schema not found in the sb-ovsdb container: %v", err)
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		server := createServerPod(f, f.Namespace.Name, "sb-upgrade-server", serverNode, nil)
+		createClientPod(f, f.Namespace.Name, "sb-upgrade-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "sb-upgrade-client", server.Status.PodIP, netexecPort)
+
+		runningVersion, err := runInOvnDBContainer(f, "sb", `ovsdb-client get-schema-version unix:$sock OVN_Southbound`)
+		framework.ExpectNoError(err, "failed to get the southbound schema version")
+		targetVersion, err := runInOvnDBContainer(f, "sb", `ovsdb-tool schema-version $schema`)
+		framework.ExpectNoError(err, "failed to get the version of the southbound schema file")
+
+		// converting the database the way an upgrade does disconnects every client, whether or not
+		// the version of the schema changes
+		By(fmt.Sprintf("Converting the southbound database from schema %s to schema %s", runningVersion, targetVersion))
+		out, err := runInOvnDBContainer(f, "sb", `ovsdb-client convert unix:$sock $schema`)
+		framework.ExpectNoError(err, "failed to convert the southbound database: %s", out)
+		convertedVersion, err := runInOvnDBContainer(f, "sb", `ovsdb-client get-schema-version unix:$sock OVN_Southbound`)
+		framework.ExpectNoError(err, "failed to get the southbound schema version")
+		if convertedVersion != targetVersion {
+			framework.Failf("Southbound database runs schema %s after the conversion, expected %s", convertedVersion, targetVersion)
+		}
+
+		By("Verifying every ovn-controller reconnects to the southbound database")
+		var lastErr error
+		err = wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+			lastErr = ovnControllersConnected(f)
+			return lastErr == nil, nil
+		})
+		framework.ExpectNoError(err, "%v", lastErr)
+
+		By("Verifying existing flows still forward and new pods get programmed")
+		expectConnectivity(f.Namespace.Name, "sb-upgrade-client", server.Status.PodIP, netexecPort)
+		newServer := createServerPod(f, f.Namespace.Name, "sb-upgrade-new-server", serverNode, nil)
+		expectConnectivity(f.Namespace.Name, "sb-upgrade-client", newServer.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep forwarding on a node partitioned from the southbound database and program new pods once it reconnects", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, partitionedNode := nodes.Items[0].Name, nodes.Items[1].Name
+
+		server := createServerPod(f, f.Namespace.Name, "sb-partition-server", partitionedNode, nil)
+		createClientPod(f, f.Namespace.Name, "sb-partition-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "sb-partition-client", server.Status.PodIP, netexecPort)
+
+		By(fmt.Sprintf("Blocking the southbound database connection of node %s", partitionedNode))
+		// make sure the partition is lifted even if the test fails half way
+		defer setSbDBBlocked(partitionedNode, false)
+		framework.ExpectNoError(setSbDBBlocked(partitionedNode, true))
+
+		By("Verifying the existing flows keep forwarding during the partition")
+		for i := 0; i < 10; i++ {
+			_, err := pokeHTTP(f.Namespace.Name, "sb-partition-client", server.Status.PodIP, netexecPort)
+			framework.ExpectNoError(err, "connectivity to node %s broke while it was partitioned from the southbound database", partitionedNode)
+			time.Sleep(3 * time.Second)
+		}
+
+		By(fmt.Sprintf("Restoring the southbound database connection of node %s", partitionedNode))
+		framework.ExpectNoError(setSbDBBlocked(partitionedNode, false))
+		var lastErr error
+		err = wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+			lastErr = ovnControllersConnected(f)
+			return lastErr == nil, nil
+		})
+		framework.ExpectNoError(err, "%v", lastErr)
+
+		By(fmt.Sprintf("Verifying a new pod on node %s gets programmed", partitionedNode))
+		newServer := createServerPod(f, f.Namespace.Name, "sb-partition-new-server", partitionedNode, nil)
+		expectConnectivity(f.Namespace.Name, "sb-partition-client", newServer.Status.PodIP, netexecPort)
+		expectConnectivity(f.Namespace.Name, "sb-partition-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep connectivity while ovn-northd restarts and program new pods and services afterwards", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+		server := createServerPod(f, f.Namespace.Name, "northd-restart-server", serverNode, nil)
+		createClientPod(f, f.Namespace.Name, "northd-restart-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "northd-restart-client", server.Status.PodIP, netexecPort)
+
+		By("Stopping ovn-northd")
+		masters, err := stopNorthd(f)
+		framework.ExpectNoError(err, "failed to stop ovn-northd")
+
+		By("Verifying the flows already in the southbound database keep forwarding")
+		for i := 0; i < 5; i++ {
+			_, err := pokeHTTP(f.Namespace.Name, "northd-restart-client", server.Status.PodIP, netexecPort)
+			framework.ExpectNoError(err, "connectivity broke while ovn-northd was down")
+			time.Sleep(pokeInterval)
+		}
+		framework.ExpectNoError(waitForNorthdRestarted(f, masters, 2*time.Minute), "ovn-northd was not restarted")
+
+		By("Verifying a new pod and service get programmed once ovn-northd is back")
+		newLabels := map[string]string{"app": "northd-restart-new-server"}
+		newServer := createServerPod(f, f.Namespace.Name, "northd-restart-new-server", serverNode, newLabels)
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "northd-restart-svc",
+			},
+			Spec: v1.ServiceSpec{
+				Selector: newLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+		expectConnectivity(f.Namespace.Name, "northd-restart-client", newServer.Status.PodIP, netexecPort)
+		expectConnectivity(f.Namespace.Name, "northd-restart-client", svc.Spec.ClusterIP, netexecPort)
+	})
+
+	It("Should converge the datapath to the topology of a restored northbound database backup", func() {
+		const backupFile string = "/tmp/e2e-nb-backup.db"
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, serverNode := nodes.Items[0].Name, nodes.Items[1].Name
+		server := createServerPod(f, f.Namespace.Name, "db-restore-server", serverNode, nil)
+		createClientPod(f, f.Namespace.Name, "db-restore-client", clientNode, nil)
+		expectConnectivity(f.Namespace.Name, "db-restore-client", server.Status.PodIP, netexecPort)
+
+		By("Backing the northbound database up")
+		framework.ExpectNoError(backupOvnDB(f, "nb", backupFile))
+		defer runInOvnDBContainer(f, "nb", "rm -f "+backupFile)
+
+		// an ACL added behind the back of ovnkube-master is not part of the backup and is never
+		// reconciled away, so only the restore can remove it
+		By(fmt.Sprintf("Diverging from the backup with an ACL dropping the traffic to %s", server.Status.PodIP))
+		match := fmt.Sprintf("ip4.dst == %s", server.Status.PodIP)
+		_, err = runNbctl(f, "acl-add", serverNode, "to-lport", "32000", match, "drop")
+		framework.ExpectNoError(err, "failed to add the dropping ACL")
+		defer runNbctl(f, "--if-exists", "acl-del", serverNode, "to-lport", "32000", match)
+		expectNoConnectivity(f.Namespace.Name, "db-restore-client", server.Status.PodIP, netexecPort)
+
+		// the southbound database is derived from the northbound one by ovn-northd, restoring the
+		// northbound database alone brings both back to the backed up topology
+		By("Restoring the northbound database backup")
+		framework.ExpectNoError(restoreOvnDB(f, "nb", backupFile))
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			flows, err := runSbctl(f, "--data=bare", "--no-heading", "--columns=match", "find", "logical_flow", "priority=33000")
+			return err == nil && !strings.Contains(flows, server.Status.PodIP), nil
+		})
+		framework.ExpectNoError(err, "the logical flow of the dropping ACL outlived the restore")
+		expectConnectivity(f.Namespace.Name, "db-restore-client", server.Status.PodIP, netexecPort)
+	})
+
+	It("Should keep quorum and the datapath up during a rolling restart of the raft database members", func() {
+		const (
+			serverName string = "raft-restart-server"
+			probeName  string = "raft-restart-probe"
+		)
+		podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-db"})
+		framework.ExpectNoError(err, "failed to list the ovnkube-db pods")
+		if len(podList.Items) < 3 {
+			framework.Skipf("Test requires the clustered databases of HA mode, found %d ovnkube-db pods", len(podList.Items))
+		}
+		for _, db := range []string{"nb", "sb"} {
+			framework.ExpectNoError(checkRaftQuorum(podList.Items, db))
+		}
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		server := createServerPod(f, f.Namespace.Name, serverName, nodes.Items[1].Name, nil)
+		createProbeLoggerPod(f, probeName, nodes.Items[0].Name, net.JoinHostPort(server.Status.PodIP, strconv.Itoa(netexecPort)))
+
+		By(fmt.Sprintf("Restarting the %d database members one at a time", len(podList.Items)))
+		records, err := restartOvnDBMembersWhileProbing(f, podList.Items, probeName)
+		framework.ExpectNoError(err)
+		if len(records) == 0 {
+			framework.Failf("Pod %s logged no request during the restarts", probeName)
+		}
+		for _, record := range records {
+			if record.responder != serverName {
+				framework.Failf("Request of pod %s at %d was answered by %q instead of %s during the restarts", probeName, record.at, record.responder, serverName)
+			}
+		}
+	})
+})
@@ -0,0 +1,895 @@
+package e2e_test
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+	utilnet "k8s.io/utils/net"
+)
+
+// assertServiceCIDRRoute verifies from inside the pod that the first address of the service CIDR
+// is routed via the OVN gateway the pod was annotated with.
+func assertServiceCIDRRoute(namespace, podName, serviceCIDR string) {
+	_, svcNet, err := net.ParseCIDR(serviceCIDR)
+	if err != nil {
+		framework.Failf("Invalid service CIDR %q: %v", serviceCIDR, err)
+	}
+	svcIP, err := utilnet.GetIndexedIP(svcNet, 1)
+	if err != nil {
+		framework.Failf("Unable to pick an address from the service CIDR %s: %v", serviceCIDR, err)
+	}
+	annotation, err := getPodNetworkAnnotation(namespace, podName)
+	if err != nil {
+		framework.Failf("Unable to get the network annotation of pod %s/%s: %v", namespace, podName, err)
+	}
+	var gatewayIP string
+	for _, gw := range annotation.Gateways {
+		if utilnet.IsIPv6String(gw) == utilnet.IsIPv6(svcIP) {
+			gatewayIP = gw
+			break
+		}
+	}
+	if gatewayIP == "" {
+		framework.Failf("Pod %s/%s has no gateway of the service CIDR family in %v", namespace, podName, annotation.Gateways)
+	}
+
+	kubectlOut, err := framework.RunKubectl("exec", podName, "--namespace="+namespace, "--", "ip", "route", "get", svcIP.String())
+	if err != nil {
+		framework.Failf("Unable to look up the route to %s in pod %s/%s: %v", svcIP, namespace, podName, err)
+	}
+	// the output looks like "10.96.0.1 via 10.244.1.1 dev eth0 src 10.244.1.3"
+	fields := strings.Fields(kubectlOut)
+	for i, field := range fields {
+		if field == "via" && i+1 < len(fields) {
+			if fields[i+1] != gatewayIP {
+				framework.Failf("Service CIDR %s is routed via %s in pod %s/%s, expected the OVN gateway %s", serviceCIDR, fields[i+1], namespace, podName, gatewayIP)
+			}
+			framework.Logf("Service CIDR %s is routed via the OVN gateway %s in pod %s/%s", serviceCIDR, gatewayIP, namespace, podName)
+			return
+		}
+	}
+	framework.Failf("Service CIDR %s has no gateway route in pod %s/%s: %s", serviceCIDR, namespace, podName, kubectlOut)
+}
+
+// getPodRoutes returns the lines of the IPv4 route table of the pod
+func getPodRoutes(namespace, podName string) ([]string, error) {
+	kubectlOut, err := framework.RunKubectl("exec", podName, "--namespace="+namespace, "--", "ip", "-4", "route", "show")
+	if err != nil {
+		return nil, err
+	}
+	var routes []string
+	for _, line := range strings.Split(kubectlOut, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			routes = append(routes, line)
+		}
+	}
+	return routes, nil
+}
+
+// assertPodPrimaryInterface verifies from inside the pod that eth0 carries the MAC and addresses
+// the pod was annotated with and that the default route points at the annotated OVN gateway.
+func assertPodPrimaryInterface(namespace, podName string) {
+	annotation, err := getPodNetworkAnnotation(namespace, podName)
+	if err != nil {
+		framework.Failf("Unable to get the network annotation of pod %s/%s: %v", namespace, podName, err)
+	}
+	kubectlOut, err := framework.RunKubectl("exec", podName, "--namespace="+namespace, "--", "ip", "-o", "addr", "show", "dev", "eth0")
+	if err != nil {
+		framework.Failf("Unable to read the addresses of eth0 in pod %s/%s: %v", namespace, podName, err)
+	}
+	for _, ipNet := range annotation.IPs {
+		if !strings.Contains(kubectlOut, " "+ipNet+" ") {
+			framework.Failf("eth0 of pod %s/%s lacks the annotated address %s: %s", namespace, podName, ipNet, kubectlOut)
+		}
+	}
+	mac, err := framework.RunKubectl("exec", podName, "--namespace="+namespace, "--", "cat", "/sys/class/net/eth0/address")
+	if err != nil {
+		framework.Failf("Unable to read the MAC of eth0 in pod %s/%s: %v", namespace, podName, err)
+	}
+	if strings.TrimSpace(mac) != annotation.MAC {
+		framework.Failf("eth0 of pod %s/%s has MAC %s instead of the annotated %s", namespace, podName, strings.TrimSpace(mac), annotation.MAC)
+	}
+	routes, err := getPodRoutes(namespace, podName)
+	if err != nil {
+		framework.Failf("Unable to read the routes of pod %s/%s: %v", namespace, podName, err)
+	}
+	for _, gw := range annotation.Gateways {
+		if !utilnet.IsIPv6String(gw) && !sets.NewString(routes...).Has("default via "+gw+" dev eth0") {
+			framework.Failf("Pod %s/%s has no default route via the OVN gateway %s: %v", namespace, podName, gw, routes)
+		}
+	}
+}
+
+// getClusterSubnets returns the pod network CIDRs of the cluster, without the host subnet length
+// the net_cidr entries of the ovn-config ConfigMap may carry (e.g. 10.244.0.0/16/24).
+func getClusterSubnets(f *framework.Framework) ([]string, error) {
+	netCIDRs, err := getOvnConfig(f, "net_cidr")
+	if err != nil {
+		return nil, err
+	}
+	var subnets []string
+	for _, netCIDR := range strings.Split(netCIDRs, ",") {
+		netCIDR = strings.TrimSpace(netCIDR)
+		if strings.Count(netCIDR, "/") == 2 {
+			netCIDR = netCIDR[:strings.LastIndex(netCIDR, "/")]
+		}
+		subnets = append(subnets, netCIDR)
+	}
+	return subnets, nil
+}
+
+// getPodSandboxID returns the ID of the sandbox of the pod from the container runtime of the KIND node
+func getPodSandboxID(nodeName, namespace, podName string) (string, error) {
+	out, err := runCommand("docker", "exec", nodeName, "crictl", "pods", "--namespace", namespace, "--name", podName, "-q")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no sandbox found for pod %s/%s on node %s", namespace, podName, nodeName)
+	}
+	return fields[0], nil
+}
+
+// runCNIDel invokes the ovn-kubernetes CNI plugin on the KIND node with a DEL command for the pod
+// sandbox, the same way the container runtime does when tearing the sandbox down.
+func runCNIDel(nodeName, sandboxID, namespace, podName string) (string, error) {
+	cniArgs := fmt.Sprintf("IgnoreUnknown=1;K8S_POD_NAMESPACE=%s;K8S_POD_NAME=%s;K8S_POD_INFRA_CONTAINER_ID=%s", namespace, podName, sandboxID)
+	return runCommand("docker", "exec", nodeName, "sh", "-c", fmt.Sprintf(
+		"CNI_COMMAND=DEL CNI_CONTAINERID=%s CNI_NETNS= CNI_IFNAME=eth0 CNI_PATH=/opt/cni/bin CNI_ARGS='%s' "+
+			"/opt/cni/bin/ovn-k8s-cni-overlay < /etc/cni/net.d/10-ovn-kubernetes.conf", sandboxID, cniArgs))
+}
+
+// logicalPortExists returns whether the northbound database holds a logical switch port of the given name
+func logicalPortExists(f *framework.Framework, portName string) (bool, error) {
+	out, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=name", "find", "logical_switch_port", "name="+portName)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+// assertLogicalPortAddresses verifies the northbound database holds exactly one logical switch
+// port of the given name and that it carries the IP address of the pod.
+func assertLogicalPortAddresses(f *framework.Framework, portName, podIP string) {
+	out, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=addresses", "find", "logical_switch_port", "name="+portName)
+	if err != nil {
+		framework.Failf("Unable to look up logical port %s: %v", portName, err)
+	}
+	var rows []string
+	for _, row := range strings.Split(out, "\n") {
+		if row = strings.TrimSpace(row); row != "" {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) != 1 {
+		framework.Failf("Expected a single logical port named %s, found %d: %v", portName, len(rows), rows)
+	}
+	// addresses look like "0a:58:0a:f4:01:03 10.244.1.3"
+	if fields := strings.Fields(rows[0]); len(fields) < 2 || fields[1] != podIP {
+		framework.Failf("Logical port %s has addresses %q, expected pod IP %s", portName, rows[0], podIP)
+	}
+}
+
+// assertPodLogicalPort verifies the logical switch port of the pod carries its IP address, is
+// tagged with its namespace and is attached to the logical switch of its node.
+func assertPodLogicalPort(f *framework.Framework, pod *v1.Pod) {
+	portName := pod.Namespace + "_" + pod.Name
+	assertLogicalPortAddresses(f, portName, pod.Status.PodIP)
+	namespace, err := runNbctl(f, "get", "logical_switch_port", portName, "external_ids:namespace")
+	if err != nil {
+		framework.Failf("Unable to get the namespace of logical port %s: %v", portName, err)
+	}
+	if namespace = strings.Trim(strings.TrimSpace(namespace), `"`); namespace != pod.Namespace {
+		framework.Failf("Logical port %s is tagged with namespace %q instead of %q", portName, namespace, pod.Namespace)
+	}
+	ports, err := runNbctl(f, "lsp-list", pod.Spec.NodeName)
+	if err != nil {
+		framework.Failf("Unable to list the ports of logical switch %s: %v", pod.Spec.NodeName, err)
+	}
+	// lines look like "a9f5e4b6-0f4c-4e2c-a4e5-7c5f3d0a1b2c (ns_pod)"
+	if !strings.Contains(ports, "("+portName+")") {
+		framework.Failf("Logical port %s is not attached to the switch of node %s", portName, pod.Spec.NodeName)
+	}
+}
+
+// getNodeSwitchSubnet returns the pod subnet of the logical switch of the node
+func getNodeSwitchSubnet(f *framework.Framework, nodeName string) (*net.IPNet, error) {
+	subnet, err := runNbctl(f, "--if-exists", "get", "logical_switch", nodeName, "other-config:subnet")
+	if err != nil {
+		return nil, err
+	}
+	_, ipNet, err := net.ParseCIDR(strings.Trim(strings.TrimSpace(subnet), `"`))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q of node %s: %v", subnet, nodeName, err)
+	}
+	return ipNet, nil
+}
+
+// getNodeReservedIPs returns the IPv4 addresses of the node subnet OVN must never hand out to
+// pods: the network and broadcast addresses, the router port and management port addresses and
+// every address of the exclude_ips of the node switch.
+func getNodeReservedIPs(f *framework.Framework, nodeName string) (sets.String, error) {
+	ipNet, err := getNodeSwitchSubnet(f, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	broadcast, err := utilnet.GetIndexedIP(ipNet, int(utilnet.RangeSize(ipNet)-1))
+	if err != nil {
+		return nil, err
+	}
+	reserved := sets.NewString(ipNet.IP.String(), broadcast.String())
+
+	routerPort, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=networks", "find", "logical_router_port", "name=rtos-"+nodeName)
+	if err != nil {
+		return nil, err
+	}
+	mgmtPort, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=addresses", "find", "logical_switch_port", "name=k8s-"+nodeName)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range append(strings.Fields(routerPort), strings.Fields(mgmtPort)...) {
+		if ip, _, err := net.ParseCIDR(addr); err == nil {
+			reserved.Insert(ip.String())
+		} else if ip := net.ParseIP(addr); ip != nil {
+			reserved.Insert(ip.String())
+		}
+	}
+
+	excludeIPs, err := runNbctl(f, "--if-exists", "get", "logical_switch", nodeName, "other-config:exclude_ips")
+	if err != nil {
+		return nil, err
+	}
+	// exclude_ips holds single addresses and first..last ranges
+	for _, entry := range strings.Fields(strings.Trim(strings.TrimSpace(excludeIPs), `"`)) {
+		bounds := strings.SplitN(entry, "..", 2)
+		first := net.ParseIP(bounds[0]).To4()
+		last := first
+		if len(bounds) == 2 {
+			last = net.ParseIP(bounds[1]).To4()
+		}
+		if first == nil || last == nil {
+			return nil, fmt.Errorf("invalid exclude_ips entry %q of node %s", entry, nodeName)
+		}
+		for ip := utilnet.BigForIP(first); ip.Cmp(utilnet.BigForIP(last)) <= 0; ip.Add(ip, big.NewInt(1)) {
+			reserved.Insert(utilnet.AddIPOffset(ip, 0).String())
+		}
+	}
+	return reserved, nil
+}
+
+// podNBState is the northbound database state kept for the pods of a namespace
+type podNBState struct {
+	ports         sets.String
+	addressSetIPs sets.String
+	natIPs        sets.String
+}
+
+// getPodNBState returns the logical ports of the pods of the namespace, the addresses of its
+// address set and the logical IPs of every NAT entry of the cluster.
+func getPodNBState(f *framework.Framework, namespace string) (*podNBState, error) {
+	ports, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=name", "find", "logical_switch_port", "external_ids:namespace="+namespace)
+	if err != nil {
+		return nil, err
+	}
+	addressSetIPs, err := getNamespaceAddressSetIPs(f, namespace)
+	if err != nil {
+		return nil, err
+	}
+	natIPs, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=logical_ip", "list", "nat")
+	if err != nil {
+		return nil, err
+	}
+	return &podNBState{
+		ports:         sets.NewString(strings.Fields(ports)...),
+		addressSetIPs: sets.NewString(addressSetIPs...),
+		natIPs:        sets.NewString(strings.Fields(natIPs)...),
+	}, nil
+}
+
+// leakedPodNBState returns a description of the state of the given ports and pod IPs that is
+// still left in the northbound database, or an empty string if there is none.
+func leakedPodNBState(state *podNBState, ports, podIPs sets.String) string {
+	var leaks []string
+	if leaked := state.ports.Intersection(ports); leaked.Len() > 0 {
+		leaks = append(leaks, fmt.Sprintf("logical ports %v", leaked.List()))
+	}
+	if leaked := state.addressSetIPs.Intersection(podIPs); leaked.Len() > 0 {
+		leaks = append(leaks, fmt.Sprintf("address set members %v", leaked.List()))
+	}
+	if leaked := state.natIPs.Intersection(podIPs); leaked.Len() > 0 {
+		leaks = append(leaks, fmt.Sprintf("NAT entries %v", leaked.List()))
+	}
+	return strings.Join(leaks, "; ")
+}
+
+// createPodsRacingNamespace creates a namespace and, without waiting for ovnkube-master to set it
+// up, keeps submitting the pods until the API server accepts them, then waits for them to run.
+func createPodsRacingNamespace(f *framework.Framework, baseName string, pods []*v1.Pod) (*v1.Namespace, []*v1.Pod) {
+	ns, err := f.ClientSet.CoreV1().Namespaces().Create(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: baseName + "-",
+		},
+	})
+	framework.ExpectNoError(err, "failed to create a namespace for %s", baseName)
+	f.AddNamespacesToDelete(ns)
+
+	for _, pod := range pods {
+		// the pods are rejected until the default service account of the namespace exists
+		err = wait.PollImmediate(100*time.Millisecond, convergeTimeout, func() (bool, error) {
+			_, err := f.ClientSet.CoreV1().Pods(ns.Name).Create(pod)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "failed to create pod %s in namespace %s", pod.Name, ns.Name)
+	}
+	var running []*v1.Pod
+	for _, pod := range pods {
+		framework.ExpectNoError(e2epod.WaitForPodNameRunningInNamespace(f.ClientSet, pod.Name, ns.Name))
+		created, err := f.ClientSet.CoreV1().Pods(ns.Name).Get(pod.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", pod.Name)
+		running = append(running, created)
+	}
+	return ns, running
+}
+
+// podsOutsideNodeSubnet returns a description of every pod whose IP is not within the default
+// network subnet of its node, as allocated in the node-subnets annotation.
+func podsOutsideNodeSubnet(f *framework.Framework, pods []*v1.Pod) ([]string, error) {
+	subnets := make(map[string]*net.IPNet)
+	var misassigned []string
+	for _, pod := range pods {
+		subnet, ok := subnets[pod.Spec.NodeName]
+		if !ok {
+			cidr, err := getNodeSubnet(f, pod.Spec.NodeName, "default")
+			if err != nil {
+				return nil, err
+			}
+			if _, subnet, err = net.ParseCIDR(cidr); err != nil {
+				return nil, fmt.Errorf("invalid subnet %q of node %s: %v", cidr, pod.Spec.NodeName, err)
+			}
+			subnets[pod.Spec.NodeName] = subnet
+		}
+		if ip := net.ParseIP(pod.Status.PodIP); ip == nil || !subnet.Contains(ip) {
+			misassigned = append(misassigned, fmt.Sprintf("pod %s has IP %q outside of subnet %s of node %s",
+				pod.Name, pod.Status.PodIP, subnet, pod.Spec.NodeName))
+		}
+	}
+	return misassigned, nil
+}
+
+// checkChurnedPod waits briefly for a pod created amid churn to be running and, if it gets there,
+// verifies the client reaches it and that it is the pod itself answering on its address. Pods
+// deleted before they run are not an error.
+func checkChurnedPod(f *framework.Framework, clientName, podName string) error {
+	if err := e2epod.WaitTimeoutForPodRunningInNamespace(f.ClientSet, podName, f.Namespace.Name, convergeTimeout); err != nil {
+		framework.Logf("Pod %s never ran amid the churn: %v", podName, err)
+		return nil
+	}
+	pod, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get running pod %s: %v", podName, err)
+	}
+	var answer string
+	err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		answer, err = pokeHTTP(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+		if err != nil {
+			return false, nil
+		}
+		if answer = strings.TrimSpace(answer); answer != podName {
+			return false, fmt.Errorf("running pod %s at %s was answered for by %q", podName, pod.Status.PodIP, answer)
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("running pod %s at %s is unreachable: %v", podName, pod.Status.PodIP, err)
+	}
+	return err
+}
+
+// createNumericNamespace creates a namespace with a random all-numeric name, drawing another name
+// when one is still taken, for instance by the namespace of an earlier run being terminated.
+func createNumericNamespace(f *framework.Framework) (*v1.Namespace, error) {
+	var ns *v1.Namespace
+	err := wait.PollImmediate(time.Second, 30*time.Second, func() (bool, error) {
+		var err error
+		ns, err = f.ClientSet.CoreV1().Namespaces().Create(&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: strconv.Itoa(100000 + rand.Intn(900000)),
+			},
+		})
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	return ns, err
+}
+
+// getNodeSubnetCapacity returns how many addresses of the node subnet are neither reserved nor
+// held by a pod of the node, and how many more pods the kubelet of the node admits.
+func getNodeSubnetCapacity(f *framework.Framework, nodeName string, reserved sets.String) (int, int, error) {
+	subnet, err := getNodeSwitchSubnet(f, nodeName)
+	if err != nil {
+		return 0, 0, err
+	}
+	node, err := f.ClientSet.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	podList, err := f.ClientSet.CoreV1().Pods("").List(metav1.ListOptions{FieldSelector: "spec.nodeName=" + nodeName})
+	if err != nil {
+		return 0, 0, err
+	}
+	active, held := 0, 0
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		active++
+		if ip := net.ParseIP(pod.Status.PodIP); !pod.Spec.HostNetwork && ip != nil && subnet.Contains(ip) && !reserved.Has(ip.String()) {
+			held++
+		}
+	}
+	free := int(utilnet.RangeSize(subnet)) - reserved.Len() - held
+	return free, int(node.Status.Allocatable.Pods().Value()) - active, nil
+}
+
+// Validate the network configuration OVN hands out to pods
+var _ = Describe("e2e pod network configuration", func() {
+	const (
+		svcname    string = "pod-netconf"
+		clientName string = "route-client"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should route the cluster service CIDR via the OVN gateway inside pods", func() {
+		svcCIDRs, err := getOvnConfig(f, "svc_cidr")
+		framework.ExpectNoError(err, "failed to get the service CIDR of the cluster")
+
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		for _, svcCIDR := range strings.Split(svcCIDRs, ",") {
+			By("Verifying the route to the service CIDR " + svcCIDR)
+			assertServiceCIDRRoute(f.Namespace.Name, clientName, strings.TrimSpace(svcCIDR))
+		}
+	})
+
+	It("Should not install the OVN default route in a pod requesting its own default gateway", func() {
+		const (
+			podName     string = "requested-gw-pod"
+			requestedGW string = "172.31.255.1"
+		)
+		clusterSubnets, err := getClusterSubnets(f)
+		framework.ExpectNoError(err, "failed to get the cluster subnets")
+
+		// a network selection element claiming the default route makes OVN withdraw its own
+		// default gateway and only route the cluster and service subnets via the node switch
+		pod := newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		pod.Annotations = map[string]string{
+			"k8s.v1.cni.cncf.io/networks": fmt.Sprintf(`[{"name":"requested-gw-net","default-route":["%s"]}]`, requestedGW),
+		}
+		pod = f.PodClient().CreateSync(pod)
+
+		annotation, err := getPodNetworkAnnotation(f.Namespace.Name, podName)
+		framework.ExpectNoError(err, "failed to get the network annotation of pod %s", podName)
+		if len(annotation.Gateways) > 0 {
+			framework.Failf("Pod %s requested its own default gateway but OVN assigned gateways %v", podName, annotation.Gateways)
+		}
+
+		By("Verifying the route table of the pod")
+		routes, err := getPodRoutes(f.Namespace.Name, podName)
+		framework.ExpectNoError(err, "failed to read the routes of pod %s", podName)
+		framework.Logf("Routes of pod %s:\n%s", podName, strings.Join(routes, "\n"))
+		for _, route := range routes {
+			// the default route may only come from the network attachment providing the requested gateway
+			if strings.HasPrefix(route, "default") && !strings.Contains(route, "via "+requestedGW) {
+				framework.Failf("Pod %s has a default route %q not pointing at the requested gateway %s", podName, route, requestedGW)
+			}
+		}
+		for _, subnet := range clusterSubnets {
+			found := false
+			for _, route := range routes {
+				if strings.HasPrefix(route, subnet+" via ") {
+					found = true
+					break
+				}
+			}
+			if !found {
+				framework.Failf("Pod %s has no route to the cluster subnet %s", podName, subnet)
+			}
+		}
+
+		By("Verifying the pod is still reachable over the cluster network")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+	})
+
+	It("Should tear a pod down idempotently when its CNI DEL is repeated", func() {
+		const podName string = "double-del-pod"
+		portName := f.Namespace.Name + "_" + podName
+
+		pod := createServerPod(f, f.Namespace.Name, podName, "", nil)
+		nodeName := pod.Spec.NodeName
+		sandboxID, err := getPodSandboxID(nodeName, f.Namespace.Name, podName)
+		framework.ExpectNoError(err, "failed to get the sandbox of pod %s", podName)
+		exists, err := logicalPortExists(f, portName)
+		framework.ExpectNoError(err, "failed to look up logical port %s", portName)
+		if !exists {
+			framework.Failf("Logical port %s of the running pod %s was not found", portName, podName)
+		}
+
+		By(fmt.Sprintf("Deleting pod %s so that the runtime tears its sandbox down", podName))
+		f.PodClient().DeleteSync(podName, nil, framework.PodDeleteTimeout)
+
+		By("Repeating the CNI DEL of the already torn down sandbox")
+		for i := 0; i < 2; i++ {
+			out, err := runCNIDel(nodeName, sandboxID, f.Namespace.Name, podName)
+			framework.ExpectNoError(err, "repeated CNI DEL %d of pod %s failed: %s", i+1, podName, out)
+		}
+
+		By(fmt.Sprintf("Verifying no logical port of pod %s is left behind", podName))
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			exists, err = logicalPortExists(f, portName)
+			return err == nil && !exists, nil
+		})
+		framework.ExpectNoError(err, "logical port %s was left behind after the repeated teardown", portName)
+	})
+
+	It("Should network a pod running in an alternative runtimeClass sandbox", func() {
+		const (
+			podName          string = "runtimeclass-pod"
+			runtimeClassName string = "kata"
+		)
+		_, err := f.ClientSet.NodeV1beta1().RuntimeClasses().Get(runtimeClassName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			framework.Skipf("Test requires the %s RuntimeClass", runtimeClassName)
+		}
+		framework.ExpectNoError(err, "failed to get RuntimeClass %s", runtimeClassName)
+
+		runtimeClass := runtimeClassName
+		pod := newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		pod.Spec.RuntimeClassName = &runtimeClass
+		pod = f.PodClient().CreateSync(pod)
+
+		By(fmt.Sprintf("Verifying OVN programmed pod %s like any other pod", podName))
+		annotation, err := getPodNetworkAnnotation(f.Namespace.Name, podName)
+		framework.ExpectNoError(err, "failed to get the network annotation of pod %s", podName)
+		if len(annotation.IPs) == 0 || !strings.HasPrefix(annotation.IPs[0], pod.Status.PodIP+"/") {
+			framework.Failf("Pod %s has IP %s but was annotated with %v", podName, pod.Status.PodIP, annotation.IPs)
+		}
+		portName := f.Namespace.Name + "_" + podName
+		exists, err := logicalPortExists(f, portName)
+		framework.ExpectNoError(err, "failed to look up logical port %s", portName)
+		if !exists {
+			framework.Failf("Logical port %s of pod %s was not found", portName, podName)
+		}
+
+		By(fmt.Sprintf("Verifying pod %s is reachable from a pod in the default runtime", podName))
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+	})
+
+	It("Should program the logical port of a pod with a maximally long name", func() {
+		// pod names are DNS subdomains of at most 253 characters
+		podName := "long-" + strings.Repeat("x", 248)
+		pod := newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		// container names are limited to a DNS label so the default one derived from the pod name is too long
+		pod.Spec.Containers[0].Name = "long-name-container"
+		pod = f.PodClient().CreateSync(pod)
+
+		portName := f.Namespace.Name + "_" + podName
+		By(fmt.Sprintf("Verifying the %d character logical port of the pod", len(portName)))
+		assertLogicalPortAddresses(f, portName, pod.Status.PodIP)
+
+		By("Verifying the pod is reachable")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+	})
+
+	It("Should give a pod sharing the host PID and IPC namespaces a regular OVN port", func() {
+		const (
+			podName    string = "host-ns-pod"
+			serverName string = "host-ns-server"
+		)
+		pod := newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		pod.Spec.HostPID = true
+		pod.Spec.HostIPC = true
+		pod = f.PodClient().CreateSync(pod)
+		if pod.Status.PodIP == pod.Status.HostIP {
+			framework.Failf("Pod %s got the host IP %s although it does not use the host network", podName, pod.Status.PodIP)
+		}
+
+		By(fmt.Sprintf("Verifying the logical port of pod %s", podName))
+		assertLogicalPortAddresses(f, f.Namespace.Name+"_"+podName, pod.Status.PodIP)
+
+		By(fmt.Sprintf("Verifying pod %s is reachable and reaches other pods", podName))
+		server := createServerPod(f, f.Namespace.Name, serverName, "", nil)
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+		expectConnectivity(f.Namespace.Name, podName, server.Status.PodIP, netexecPort)
+	})
+
+	It("Should never assign a reserved address of the node subnet to a pod, nor any address once the subnet is exhausted", func() {
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+		reserved, err := getNodeReservedIPs(f, nodeName)
+		framework.ExpectNoError(err, "failed to get the reserved addresses of node %s", nodeName)
+		framework.Logf("Reserved addresses of node %s: %v", nodeName, reserved.List())
+		free, slots, err := getNodeSubnetCapacity(f, nodeName, reserved)
+		framework.ExpectNoError(err, "failed to get the free addresses of node %s", nodeName)
+		// the kubelet of a KIND node admits about 110 pods, fewer than the addresses of its /24, so
+		// the subnet is only filled up when the kubelet admits one pod more than it has room for
+		pods, exhaust := 50, free+1 <= slots
+		if exhaust {
+			pods = free
+		} else {
+			framework.Logf("Node %s admits %d more pods, too few to exhaust the %d free addresses of its subnet", nodeName, slots, free)
+		}
+		if pods > free {
+			pods = free
+		}
+
+		By(fmt.Sprintf("Creating %d pods on node %s with %d free addresses", pods, nodeName, free))
+		var batch []*v1.Pod
+		for i := 0; i < pods; i++ {
+			batch = append(batch, newAgnhostPod(fmt.Sprintf("ipam-pod-%d", i), nodeName, nil, "pause"))
+		}
+		batch = f.PodClient().CreateBatch(batch)
+
+		for _, pod := range batch {
+			if reserved.Has(pod.Status.PodIP) {
+				framework.Failf("Pod %s was assigned the reserved address %s of node %s", pod.Name, pod.Status.PodIP, nodeName)
+			}
+			annotation, err := getPodNetworkAnnotation(f.Namespace.Name, pod.Name)
+			framework.ExpectNoError(err, "failed to get the network annotation of pod %s", pod.Name)
+			for _, ipNet := range annotation.IPs {
+				if ip, _, err := net.ParseCIDR(ipNet); err == nil && reserved.Has(ip.String()) {
+					framework.Failf("Pod %s was annotated with the reserved address %s of node %s", pod.Name, ip, nodeName)
+				}
+			}
+		}
+
+		if !exhaust {
+			return
+		}
+		By(fmt.Sprintf("Verifying one more pod on node %s gets no address", nodeName))
+		extra := f.PodClient().Create(newAgnhostPod("ipam-pod-extra", nodeName, nil, "pause"))
+		err = wait.PollImmediate(pokeInterval, time.Minute, func() (bool, error) {
+			_, err := getPodNetworkAnnotation(f.Namespace.Name, extra.Name)
+			return err == nil, nil
+		})
+		if err != wait.ErrWaitTimeout {
+			framework.Failf("Pod %s was allocated an address although the subnet of node %s is exhausted", extra.Name, nodeName)
+		}
+		extra, err = f.PodClient().Get(extra.Name, metav1.GetOptions{})
+		framework.ExpectNoError(err, "failed to get pod %s", extra.Name)
+		if extra.Status.Phase != v1.PodPending || extra.Status.PodIP != "" {
+			framework.Failf("Pod %s is %s with address %q although the subnet of node %s is exhausted", extra.Name, extra.Status.Phase, extra.Status.PodIP, nodeName)
+		}
+	})
+
+	It("Should reap the northbound state of a burst of pods deleted at once", func() {
+		const pods = 40
+
+		By(fmt.Sprintf("Creating %d pods", pods))
+		var batch []*v1.Pod
+		for i := 0; i < pods; i++ {
+			batch = append(batch, newAgnhostPod(fmt.Sprintf("burst-pod-%d", i), "", nil, "pause"))
+		}
+		batch = f.PodClient().CreateBatch(batch)
+		ports, podIPs := sets.NewString(), sets.NewString()
+		for _, pod := range batch {
+			ports.Insert(f.Namespace.Name + "_" + pod.Name)
+			podIPs.Insert(pod.Status.PodIP)
+		}
+		var state *podNBState
+		err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			var err error
+			state, err = getPodNBState(f, f.Namespace.Name)
+			return err == nil && state.ports.IsSuperset(ports) && state.addressSetIPs.IsSuperset(podIPs), nil
+		})
+		framework.ExpectNoError(err, "the northbound database does not hold every pod: %+v", state)
+
+		By(fmt.Sprintf("Deleting the %d pods concurrently", pods))
+		var wg sync.WaitGroup
+		errs := make(chan error, pods)
+		zero := int64(0)
+		for _, pod := range batch {
+			wg.Add(1)
+			go func(name string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				errs <- f.ClientSet.CoreV1().Pods(f.Namespace.Name).Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: &zero})
+			}(pod.Name)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			framework.ExpectNoError(err, "failed to delete a pod")
+		}
+
+		By("Verifying every logical port, address set member and NAT entry of the pods is reaped")
+		var leaks string
+		err = wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+			state, err := getPodNBState(f, f.Namespace.Name)
+			if err != nil {
+				framework.Logf("Unable to read the northbound state: %v", err)
+				return false, nil
+			}
+			leaks = leakedPodNBState(state, ports, podIPs)
+			return leaks == "", nil
+		})
+		framework.ExpectNoError(err, "the northbound database still holds the state of deleted pods: %s", leaks)
+	})
+
+	It("Should fully network pods created before their namespace is set up", func() {
+		const (
+			rounds = 3
+			pods   = 3
+		)
+		clientName := "racing-ns-client"
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		dbPod, err := getOvnDBPodName(f)
+		framework.ExpectNoError(err)
+
+		for round := 0; round < rounds; round++ {
+			By(fmt.Sprintf("Creating %d pods right after their namespace in round %d", pods, round))
+			var batch []*v1.Pod
+			for i := 0; i < pods; i++ {
+				batch = append(batch, newAgnhostPod(fmt.Sprintf("racing-pod-%d", i), "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)))
+			}
+			ns, running := createPodsRacingNamespace(f, "racing-ns", batch)
+
+			By(fmt.Sprintf("Verifying the pods of namespace %s got their ports, address set entries and connectivity", ns.Name))
+			var podIPs []string
+			for _, pod := range running {
+				podIPs = append(podIPs, pod.Status.PodIP)
+				assertLogicalPortAddresses(f, ns.Name+"_"+pod.Name, pod.Status.PodIP)
+				expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+			}
+			assertNamespaceAddressSet(dbPod, ns.Name, podIPs)
+		}
+	})
+
+	It("Should keep the OVN interface of a pod authoritative after an init container changes its network namespace", func() {
+		const (
+			podName    string = "netns-init-pod"
+			extraIface string = "extra0"
+			extraRoute string = "198.51.100.0/24"
+		)
+		pod := newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+		pod.Spec.InitContainers = []v1.Container{{
+			Name:    "netns-init",
+			Image:   framework.AgnHostImage,
+			Command: []string{"sh", "-c", fmt.Sprintf("ip link add %[1]s type dummy && ip addr add 192.0.2.10/24 dev %[1]s && ip link set %[1]s up && ip route add %[2]s dev %[1]s", extraIface, extraRoute)},
+			SecurityContext: &v1.SecurityContext{
+				Capabilities: &v1.Capabilities{Add: []v1.Capability{"NET_ADMIN"}},
+			},
+		}}
+		pod = f.PodClient().CreateSync(pod)
+
+		By("Verifying the routes added by the init container survived next to the OVN ones")
+		routes, err := getPodRoutes(f.Namespace.Name, podName)
+		framework.ExpectNoError(err, "failed to read the routes of pod %s", podName)
+		framework.Logf("Routes of pod %s:\n%s", podName, strings.Join(routes, "\n"))
+		found := false
+		for _, route := range routes {
+			if strings.HasPrefix(route, extraRoute+" dev "+extraIface) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			framework.Failf("Pod %s lost the route to %s added by its init container", podName, extraRoute)
+		}
+
+		By("Verifying the primary interface still matches its OVN programming")
+		assertPodPrimaryInterface(f.Namespace.Name, podName)
+		assertLogicalPortAddresses(f, f.Namespace.Name+"_"+podName, pod.Status.PodIP)
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+	})
+
+	It("Should network a pod with an all-numeric name in an all-numeric namespace", func() {
+		const podName string = "1234"
+		ns, err := createNumericNamespace(f)
+		framework.ExpectNoError(err, "failed to create an all-numeric namespace")
+		f.AddNamespacesToDelete(ns)
+		framework.ExpectNoError(framework.WaitForDefaultServiceAccountInNamespace(f.ClientSet, ns.Name))
+
+		By(fmt.Sprintf("Creating pod %s in namespace %s", podName, ns.Name))
+		pod := f.PodClientNS(ns.Name).CreateSync(newAgnhostPod(podName, "", nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)))
+
+		By("Verifying the logical port and the address set of the pod")
+		assertPodLogicalPort(f, pod)
+		dbPod, err := getOvnDBPodName(f)
+		framework.ExpectNoError(err)
+		assertNamespaceAddressSet(dbPod, ns.Name, []string{pod.Status.PodIP})
+
+		By("Verifying the pod is reachable")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, pod.Status.PodIP, netexecPort)
+	})
+
+	It("Should only serve traffic to running pods while pods churn faster than flows are programmed", func() {
+		const pods = 40
+
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		nodeName := nodes.Items[0].Name
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+
+		By(fmt.Sprintf("Creating and deleting %d pods at once on node %s", pods, nodeName))
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		var failures []string
+		zero := int64(0)
+		for i := 0; i < pods; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				podName := fmt.Sprintf("churn-pod-%d", i)
+				podClient := f.ClientSet.CoreV1().Pods(f.Namespace.Name)
+				_, err := podClient.Create(newAgnhostPod(podName, nodeName, nil, "netexec", fmt.Sprintf("--http-port=%d", netexecPort)))
+				if err == nil && i%2 == 0 {
+					// every other pod lives until it is verified, the rest are deleted right away
+					err = checkChurnedPod(f, clientName, podName)
+				}
+				if delErr := podClient.Delete(podName, &metav1.DeleteOptions{GracePeriodSeconds: &zero}); err == nil && !apierrors.IsNotFound(delErr) {
+					err = delErr
+				}
+				if err != nil {
+					lock.Lock()
+					defer lock.Unlock()
+					failures = append(failures, fmt.Sprintf("%s: %v", podName, err))
+				}
+			}(i)
+		}
+		wg.Wait()
+		if len(failures) > 0 {
+			framework.Failf("Pods running amid the churn were not networked correctly:\n%s", strings.Join(failures, "\n"))
+		}
+	})
+
+	It("Should assign every pod an IP from the subnet of its own node", func() {
+		const pods = 30
+
+		By(fmt.Sprintf("Creating %d pods spread over the nodes", pods))
+		var batch []*v1.Pod
+		for i := 0; i < pods; i++ {
+			batch = append(batch, newAgnhostPod(fmt.Sprintf("subnet-pod-%d", i), "", nil, "pause"))
+		}
+		batch = f.PodClient().CreateBatch(batch)
+
+		By("Verifying the IP of every pod is within the subnet of its node")
+		misassigned, err := podsOutsideNodeSubnet(f, batch)
+		framework.ExpectNoError(err, "failed to get the node subnets")
+		if len(misassigned) > 0 {
+			framework.Failf("Pods were assigned IPs of another subnet than their node's:\n%s", strings.Join(misassigned, "\n"))
+		}
+	})
+})
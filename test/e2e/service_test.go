@@ -0,0 +1,1152 @@
+package e2e_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+	e2enode "k8s.io/kubernetes/test/e2e/framework/node"
+	e2epod "k8s.io/kubernetes/test/e2e/framework/pod"
+	e2eservice "k8s.io/kubernetes/test/e2e/framework/service"
+)
+
+// port the netexec pods of the service test jig listen on
+const jigServicePort = 80
+
+// getLoadBalancerBackends returns the backends OVN load balances the "ip:port" VIP to, taken from
+// the first load balancer carrying the VIP, or nil when no load balancer carries it.
+func getLoadBalancerBackends(f *framework.Framework, vip string) ([]string, error) {
+	kubectlOut, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=vips", "list", "load_balancer")
+	if err != nil {
+		return nil, err
+	}
+	return parseVIPBackends(kubectlOut, vip), nil
+}
+
+// getClusterLoadBalancerBackends returns the backends the cluster wide load balancer of the
+// protocol load balances the "ip:port" VIP to, or nil when it does not carry the VIP.
+func getClusterLoadBalancerBackends(f *framework.Framework, protocol v1.Protocol, vip string) ([]string, error) {
+	kubectlOut, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=vips", "find", "load_balancer",
+		fmt.Sprintf("external_ids:k8s-cluster-lb-%s=yes", strings.ToLower(string(protocol))))
+	if err != nil {
+		return nil, err
+	}
+	return parseVIPBackends(kubectlOut, vip), nil
+}
+
+// parseVIPBackends returns the backends of the "ip:port" VIP in the bare vips column output of
+// ovn-nbctl, or nil when the VIP is not found.
+func parseVIPBackends(kubectlOut, vip string) []string {
+	// with --data=bare each vips map prints as space separated vip=backend1,backend2 pairs
+	for _, entry := range strings.Fields(kubectlOut) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] != vip {
+			continue
+		}
+		if parts[1] == "" {
+			return []string{}
+		}
+		return strings.Split(parts[1], ",")
+	}
+	return nil
+}
+
+// waitForLoadBalancerBackends polls until OVN load balances the "ip:port" VIP to exactly the
+// wanted backends, an empty set meaning the VIP is absent or has no backends.
+func waitForLoadBalancerBackends(f *framework.Framework, vip string, want sets.String) error {
+	var got sets.String
+	err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		backends, err := getLoadBalancerBackends(f, vip)
+		if err != nil {
+			framework.Logf("Unable to read the load balancer backends of %s: %v", vip, err)
+			return false, nil
+		}
+		got = sets.NewString(backends...)
+		return got.Equal(want), nil
+	})
+	if err != nil {
+		return fmt.Errorf("load balancer of %s has backends %v, expected %v", vip, got.List(), want.List())
+	}
+	return nil
+}
+
+// hasServiceRejectACL reports whether OVN rejects the TCP connections to ip:port with the ACL
+// ovnkube-master programs for a service without endpoints
+func hasServiceRejectACL(f *framework.Framework, ip string, port int) (bool, error) {
+	matches, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=match", "find", "acl", "action=reject")
+	if err != nil {
+		return false, err
+	}
+	want := fmt.Sprintf("dst==%s && tcp && tcp.dst==%d", ip, port)
+	for _, match := range strings.Split(matches, "\n") {
+		if strings.Contains(match, want) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getLoadBalancerState returns the number of OVN load balancers and the "ip:port" VIPs they carry
+func getLoadBalancerState(f *framework.Framework) (int, sets.String, error) {
+	uuids, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=_uuid", "list", "load_balancer")
+	if err != nil {
+		return 0, nil, err
+	}
+	kubectlOut, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=vips", "list", "load_balancer")
+	if err != nil {
+		return 0, nil, err
+	}
+	vips := sets.NewString()
+	for _, entry := range strings.Fields(kubectlOut) {
+		vips.Insert(strings.SplitN(entry, "=", 2)[0])
+	}
+	return len(strings.Fields(uuids)), vips, nil
+}
+
+const (
+	// load balancers attached to logical switches only
+	lbScopeSwitch = "switch"
+	// load balancers attached to gateway routers only
+	lbScopeGatewayRouter = "gateway-router"
+)
+
+// getLBScope returns whether the load balancers carrying the "ip:port" VIP, as read from the
+// ovnkube-db pod, are attached to logical switches, to gateway routers, or to both.
+func getLBScope(ovnPodName, vip string) (string, error) {
+	kubectlOut, err := runNbctlInPod(ovnPodName, "--data=bare", "--no-heading", "--columns=_uuid,vips", "list", "load_balancer")
+	if err != nil {
+		return "", err
+	}
+	var onSwitch, onRouter bool
+	// each load balancer prints as its uuid line followed by its vips line
+	for _, record := range strings.Split(kubectlOut, "\n\n") {
+		lines := strings.SplitN(strings.TrimSpace(record), "\n", 2)
+		if len(lines) != 2 || parseVIPBackends(lines[1], vip) == nil {
+			continue
+		}
+		uuid := strings.TrimSpace(lines[0])
+		switches, err := runNbctlInPod(ovnPodName, "--data=bare", "--no-heading", "--columns=name", "find", "logical_switch", "load_balancer{>=}"+uuid)
+		if err != nil {
+			return "", err
+		}
+		routers, err := runNbctlInPod(ovnPodName, "--data=bare", "--no-heading", "--columns=name", "find", "logical_router", "load_balancer{>=}"+uuid)
+		if err != nil {
+			return "", err
+		}
+		onSwitch = onSwitch || strings.TrimSpace(switches) != ""
+		onRouter = onRouter || strings.TrimSpace(routers) != ""
+	}
+	switch {
+	case onSwitch && onRouter:
+		return lbScopeSwitch + "," + lbScopeGatewayRouter, nil
+	case onSwitch:
+		return lbScopeSwitch, nil
+	case onRouter:
+		return lbScopeGatewayRouter, nil
+	}
+	return "", fmt.Errorf("no attached load balancer carries VIP %s", vip)
+}
+
+// assertLBScope waits for the load balancers carrying the "ip:port" VIP to be attached with the
+// wanted scope, failing the test if they are not.
+func assertLBScope(ovnPodName, svcVIP string, wantScope string) {
+	var scope string
+	var lastErr error
+	err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		scope, lastErr = getLBScope(ovnPodName, svcVIP)
+		return lastErr == nil && scope == wantScope, nil
+	})
+	framework.ExpectNoError(err, "VIP %s has load balancer scope %q, expected %q: %v", svcVIP, scope, wantScope, lastErr)
+}
+
+// getEndpointAddresses returns the "ip:port" of every ready endpoint of the service
+func getEndpointAddresses(f *framework.Framework, namespace, serviceName string) (sets.String, error) {
+	ep, err := f.ClientSet.CoreV1().Endpoints(namespace).Get(serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	addresses := sets.NewString()
+	for _, subset := range ep.Subsets {
+		for _, address := range subset.Addresses {
+			for _, port := range subset.Ports {
+				addresses.Insert(net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// sendUDPEcho sends a single netexec "echo" datagram carrying size bytes of payload from the
+// source pod to host:port and returns the payload echoed back.
+func sendUDPEcho(srcNamespace, srcPodName, host string, port, size int) (string, error) {
+	cmd := fmt.Sprintf("printf 'echo %%s' \"$(head -c %d /dev/zero | tr '\\0' x)\" | nc -u -w 3 %s %d", size, host, port)
+	return framework.RunKubectl("exec", srcPodName, "--namespace="+srcNamespace, "--", "bash", "-c", cmd)
+}
+
+// probeRejected connects from the source pod to host:port with a generous connect timeout and
+// returns how long the attempt took, or an error if the connection unexpectedly succeeded.
+func probeRejected(srcNamespace, srcPodName, host string, port int) (time.Duration, error) {
+	url := fmt.Sprintf("http://%s/hostname", net.JoinHostPort(host, strconv.Itoa(port)))
+	start := time.Now()
+	out, err := framework.RunKubectl("exec", srcPodName, "--namespace="+srcNamespace, "--",
+		"curl", "--connect-timeout", "10", "-s", "-f", url)
+	if err == nil {
+		return 0, fmt.Errorf("connection to %s from %s/%s succeeded: %s", url, srcNamespace, srcPodName, out)
+	}
+	return time.Since(start), nil
+}
+
+// getIptablesRuleCounters returns the packet counters of the rules of the iptables chain, keyed
+// by the rule specification without its counters.
+func getIptablesRuleCounters(pod *v1.Pod, chain string) (map[string]int, error) {
+	out, err := runInPodNetns(pod, "iptables", "-w", "-S", chain, "-v")
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		// rules look like "-A INPUT -p tcp -m tcp --dport 80 -c 3 180"
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "-A" || fields[len(fields)-3] != "-c" {
+			continue
+		}
+		packets, err := strconv.Atoi(fields[len(fields)-2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid counters in iptables rule %q: %v", line, err)
+		}
+		counters[strings.Join(fields[:len(fields)-3], " ")] = packets
+	}
+	return counters, nil
+}
+
+// kubeProxyRunning returns whether kube-proxy pods run in the cluster next to ovn-kubernetes
+func kubeProxyRunning(f *framework.Framework) (bool, error) {
+	podList, err := f.ClientSet.CoreV1().Pods("kube-system").List(metav1.ListOptions{LabelSelector: "k8s-app=kube-proxy"})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodRunning {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// image shipping a socat built with SCTP support, which the agnhost image lacks
+const sctpImage = "docker.io/alpine/socat:1.7.3.4-r0"
+
+// newSCTPPod returns a pod running the shell command in the socat image
+func newSCTPPod(podName string, labels map[string]string, command string) *v1.Pod {
+	pod := newAgnhostPod(podName, "", labels)
+	pod.Spec.Containers[0].Image = sctpImage
+	pod.Spec.Containers[0].Command = []string{"sh", "-c", command}
+	return pod
+}
+
+// nodeSupportsSCTP loads the sctp module of the kernel the KIND node container runs on, returning
+// whether SCTP sockets can be opened there.
+func nodeSupportsSCTP(nodeName string) bool {
+	_, err := runCommand("docker", "exec", nodeName, "sh", "-c", "modprobe sctp 2>/dev/null; test -d /proc/sys/net/sctp")
+	return err == nil
+}
+
+// probeSCTP connects from the source pod, created by newSCTPPod, to host:port over SCTP with socat
+// and returns what the server answered.
+func probeSCTP(srcNamespace, srcPodName, host string, port int) (string, error) {
+	return framework.RunKubectl("exec", srcPodName, "--namespace="+srcNamespace, "--",
+		"socat", "-T", "5", "STDIO", fmt.Sprintf("SCTP:%s", net.JoinHostPort(host, strconv.Itoa(port))))
+}
+
+// pokeClientIP execs curl in the source pod against the netexec clientip endpoint on host:port
+// and returns the source address the server saw, without its port.
+func pokeClientIP(srcNamespace, srcPodName, host string, port int) (string, error) {
+	url := fmt.Sprintf("http://%s/clientip", net.JoinHostPort(host, strconv.Itoa(port)))
+	out, err := framework.RunKubectl("exec", srcPodName, "--namespace="+srcNamespace, "--",
+		"curl", "--connect-timeout", "2", "--max-time", "5", "-s", "-f", url)
+	if err != nil {
+		return "", err
+	}
+	clientIP, _, err := net.SplitHostPort(strings.TrimSpace(out))
+	return clientIP, err
+}
+
+// probeRecord is a request made by a probe logger pod, with an empty responder when it failed
+type probeRecord struct {
+	at        int64
+	responder string
+}
+
+// createProbeLoggerPod creates a pod requesting the netexec hostname endpoint of the "ip:port"
+// VIP five times a second, logging the unix time and the responder of each request.
+func createProbeLoggerPod(f *framework.Framework, podName, nodeName, vip string) *v1.Pod {
+	pod := newAgnhostPod(podName, nodeName, nil)
+	pod.Spec.Containers[0].Command = []string{"bash", "-c",
+		fmt.Sprintf("while true; do echo \"$(date +%%s) $(curl -s --max-time 1 http://%s/hostname)\"; sleep 0.2; done", vip)}
+	return f.PodClient().CreateSync(pod)
+}
+
+// getProbeRecords returns the requests logged so far by the probe logger pod
+func getProbeRecords(f *framework.Framework, podName string) ([]probeRecord, error) {
+	logs, err := e2epod.GetPodLogs(f.ClientSet, f.Namespace.Name, podName, podName+"-container")
+	if err != nil {
+		return nil, err
+	}
+	var records []probeRecord
+	for _, line := range strings.Split(logs, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || len(fields) > 2 {
+			continue
+		}
+		at, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		record := probeRecord{at: at}
+		if len(fields) == 2 {
+			record.responder = fields[1]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// longestProbeOutage returns the number of seconds spanned by the longest run of failed requests
+// among the records, from the first failed request to the next answered one, or to the last
+// record if none was answered afterwards.
+func longestProbeOutage(records []probeRecord) int64 {
+	var longest, failingSince int64
+	failing := false
+	for _, record := range records {
+		if record.responder == "" {
+			if !failing {
+				failing, failingSince = true, record.at
+			}
+			continue
+		}
+		if failing && record.at-failingSince > longest {
+			longest = record.at - failingSince
+		}
+		failing = false
+	}
+	if failing && len(records) > 0 && records[len(records)-1].at-failingSince > longest {
+		longest = records[len(records)-1].at - failingSince
+	}
+	return longest
+}
+
+// drainNodeWhileProbing evicts the pods matching the selector from the node, honouring their
+// disruption budgets, and returns the requests the probe logger pod made during the drain.
+func drainNodeWhileProbing(f *framework.Framework, nodeName string, podSelector map[string]string, probePodName string) ([]probeRecord, error) {
+	start := time.Now().Unix()
+	_, err := framework.RunKubectl("drain", nodeName, "--pod-selector="+labels.SelectorFromSet(podSelector).String(),
+		"--ignore-daemonsets", "--delete-local-data", "--timeout=5m")
+	if err != nil {
+		return nil, fmt.Errorf("failed to drain node %s: %v", nodeName, err)
+	}
+	// let the probes of the last rescheduled backends make it into the log
+	time.Sleep(5 * time.Second)
+	records, err := getProbeRecords(f, probePodName)
+	if err != nil {
+		return nil, err
+	}
+	var during []probeRecord
+	for _, record := range records {
+		if record.at >= start {
+			during = append(during, record)
+		}
+	}
+	return during, nil
+}
+
+// hybridOverlayNodeSubnetAnnotation is set on the nodes the hybrid overlay networks instead of OVN
+const hybridOverlayNodeSubnetAnnotation = "k8s.ovn.org/hybrid-overlay-node-subnet"
+
+// createHybridServiceBackends creates a netexec backend with the labels on a node networked by
+// OVN and another one on a node networked by the hybrid overlay, in that order. It returns no
+// pods if the cluster lacks either kind of node.
+func createHybridServiceBackends(f *framework.Framework, namePrefix string, labels map[string]string) []*v1.Pod {
+	nodes, err := e2enode.GetReadySchedulableNodes(f.ClientSet)
+	framework.ExpectNoError(err, "failed to list the nodes")
+	var ovnNode, hybridNode string
+	for _, node := range nodes.Items {
+		if _, ok := node.Annotations[hybridOverlayNodeSubnetAnnotation]; ok {
+			hybridNode = node.Name
+		} else {
+			ovnNode = node.Name
+		}
+	}
+	if ovnNode == "" || hybridNode == "" {
+		return nil
+	}
+	return []*v1.Pod{
+		createServerPod(f, f.Namespace.Name, namePrefix+"-ovn", ovnNode, labels),
+		createServerPod(f, f.Namespace.Name, namePrefix+"-hybrid", hybridNode, labels),
+	}
+}
+
+// Validate the OVN load balancers backing Kubernetes services
+var _ = Describe("e2e service load balancing", func() {
+	const (
+		svcname    string = "svc-lb"
+		clientName string = "svc-client"
+	)
+
+	f := framework.NewDefaultFramework(svcname)
+
+	It("Should program every backend of a service with a large endpoint set", func() {
+		const sampleSize = 10
+		// thousands of backends are what the test is about, but a KIND cluster only schedules a
+		// hundred or so, hence the default of the flag
+		replicas := *largeServiceBackends
+		if replicas < sampleSize {
+			framework.Failf("The large endpoint set test needs at least %d backends, got %d", sampleSize, replicas)
+		}
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "large-svc")
+		_, err := jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(replicas)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to run %d service backends", replicas)
+
+		start := time.Now()
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+
+		By(fmt.Sprintf("Waiting for OVN to load balance %s to all %d backends", vip, replicas))
+		var backends []string
+		err = wait.PollImmediate(pokeInterval, 5*time.Minute, func() (bool, error) {
+			backends, err = getLoadBalancerBackends(f, vip)
+			if err != nil {
+				framework.Logf("Unable to read the load balancer backends of %s: %v", vip, err)
+				return false, nil
+			}
+			return len(backends) == replicas, nil
+		})
+		framework.ExpectNoError(err, "OVN programmed %d of the %d backends of %s", len(backends), replicas, vip)
+		framework.Logf("OVN programmed the %d backends of %s in %v", replicas, vip, time.Since(start))
+
+		endpoints, err := getEndpointAddresses(f, f.Namespace.Name, svc.Name)
+		framework.ExpectNoError(err, "failed to get the endpoints of the service")
+		if programmed := sets.NewString(backends...); !programmed.Equal(endpoints) {
+			framework.Failf("Load balancer backends differ from the endpoints, missing %v, unexpected %v",
+				endpoints.Difference(programmed).List(), programmed.Difference(endpoints).List())
+		}
+
+		By(fmt.Sprintf("Probing a sample of %d backends and the service VIP", sampleSize))
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		for _, i := range rand.Perm(len(backends))[:sampleSize] {
+			host, _, err := net.SplitHostPort(backends[i])
+			framework.ExpectNoError(err, "invalid backend %q", backends[i])
+			expectConnectivity(f.Namespace.Name, clientName, host, jigServicePort)
+		}
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+	})
+
+	It("Should never send traffic to a deleted backend once it is removed from the load balancer", func() {
+		const streamClientName = "svc-stream-client"
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "shrinking-svc")
+		_, err := jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(3)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to run the service backends")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+
+		createProbeLoggerPod(f, streamClientName, "", vip)
+
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the service backends")
+		deleted := podList.Items[0]
+		deletedBackend := net.JoinHostPort(deleted.Status.PodIP, strconv.Itoa(jigServicePort))
+
+		By(fmt.Sprintf("Waiting for the client to be served by backend %s", deleted.Name))
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			records, err := getProbeRecords(f, streamClientName)
+			for _, record := range records {
+				if record.responder == deleted.Name {
+					return true, nil
+				}
+			}
+			return false, err
+		})
+		framework.ExpectNoError(err, "backend %s never answered the client", deleted.Name)
+
+		By(fmt.Sprintf("Deleting backend %s and waiting for it to leave the load balancer", deleted.Name))
+		err = f.ClientSet.CoreV1().Pods(f.Namespace.Name).Delete(deleted.Name, &metav1.DeleteOptions{})
+		framework.ExpectNoError(err, "failed to delete backend %s", deleted.Name)
+		err = wait.PollImmediate(500*time.Millisecond, convergeTimeout, func() (bool, error) {
+			backends, err := getLoadBalancerBackends(f, vip)
+			return err == nil && backends != nil && !sets.NewString(backends...).Has(deletedBackend), nil
+		})
+		framework.ExpectNoError(err, "backend %s was not removed from the load balancer of %s", deletedBackend, vip)
+		removedAt := time.Now().Unix()
+
+		By("Verifying no request was answered by the deleted backend after its removal")
+		time.Sleep(10 * time.Second)
+		records, err := getProbeRecords(f, streamClientName)
+		framework.ExpectNoError(err, "failed to get the logs of the client")
+		for _, record := range records {
+			// requests of the second the backend was removed in are ambiguous, only later ones count
+			if record.responder == deleted.Name && record.at > removedAt {
+				framework.Failf("Deleted backend %s answered a request at %d, after its removal at %d", deleted.Name, record.at, removedAt)
+			}
+		}
+	})
+
+	It("Should deliver a fragmented UDP datagram sent to a service VIP", func() {
+		// netexec reads UDP datagrams into a buffer of 2048 bytes, the "echo " prefix included
+		const maxPayload = 2000
+		mtuConfig, err := getOvnConfig(f, "mtu")
+		framework.ExpectNoError(err, "failed to get the MTU of the cluster")
+		podMTU, err := strconv.Atoi(mtuConfig)
+		framework.ExpectNoError(err, "invalid cluster MTU %q", mtuConfig)
+		payload := podMTU + 200
+		if payload > maxPayload {
+			framework.Skipf("Cluster MTU %d is too large to fragment a datagram netexec can receive", podMTU)
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "udp-frag-svc")
+		_, err = jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateUDPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+
+		By(fmt.Sprintf("Sending a %d byte datagram above the pod MTU of %d to %s", payload, podMTU, svc.Spec.ClusterIP))
+		expected := strings.Repeat("x", payload)
+		var echoed string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			echoed, err = sendUDPEcho(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort, payload)
+			return err == nil && strings.TrimSpace(echoed) == expected, nil
+		})
+		framework.ExpectNoError(err, "the backend did not echo the full %d byte payload, got %d bytes", payload, len(strings.TrimSpace(echoed)))
+	})
+
+	It("Should promptly reject connections to a service without endpoints until a backend is added", func() {
+		// well below the connect timeout of the probe, so that only a reset or reject passes
+		const rejectTimeout = 5 * time.Second
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "empty-svc")
+		_, err := jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(0)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to create the service replication controller")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+
+		By(fmt.Sprintf("Verifying connections to %s are rejected while the service has no endpoints", svc.Spec.ClusterIP))
+		var took time.Duration
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			took, err = probeRejected(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+			framework.ExpectNoError(err)
+			return took < rejectTimeout, nil
+		})
+		framework.ExpectNoError(err, "connections to the service without endpoints hung for %v instead of being rejected", took)
+
+		By("Scaling the service up to a single backend")
+		framework.ExpectNoError(jig.Scale(1), "failed to scale the service up")
+		start := time.Now()
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+		framework.Logf("Service %s became reachable %v after its backend became ready", svc.Name, time.Since(start))
+	})
+
+	It("Should leave no load balancer state behind after rapid service churn", func() {
+		const (
+			rounds   = 5
+			services = 10
+		)
+		baselineLBs, _, err := getLoadBalancerState(f)
+		framework.ExpectNoError(err, "failed to read the load balancers")
+
+		churned := sets.NewString()
+		for round := 0; round < rounds; round++ {
+			By(fmt.Sprintf("Creating and deleting %d services, round %d of %d", services, round+1, rounds))
+			var names []string
+			for i := 0; i < services; i++ {
+				svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: fmt.Sprintf("churn-svc-%d-%d", round, i),
+					},
+					Spec: v1.ServiceSpec{
+						Selector: map[string]string{"app": "churn"},
+						Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: jigServicePort}},
+					},
+				})
+				framework.ExpectNoError(err, "failed to create a churned service")
+				names = append(names, svc.Name)
+				churned.Insert(net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort)))
+			}
+			for _, name := range names {
+				err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Delete(name, &metav1.DeleteOptions{})
+				framework.ExpectNoError(err, "failed to delete service %s", name)
+			}
+		}
+
+		By("Verifying the load balancers return to their baseline")
+		var lbs int
+		var leaked sets.String
+		err = wait.PollImmediate(pokeInterval, 2*time.Minute, func() (bool, error) {
+			var vips sets.String
+			lbs, vips, err = getLoadBalancerState(f)
+			if err != nil {
+				return false, nil
+			}
+			leaked = vips.Intersection(churned)
+			return lbs == baselineLBs && leaked.Len() == 0, nil
+		})
+		framework.ExpectNoError(err, "found %d load balancers instead of %d and leaked VIPs %v", lbs, baselineLBs, leaked.List())
+	})
+
+	It("Should attach backends to a service once its selector starts matching pods", func() {
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "late-match-svc")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+
+		By(fmt.Sprintf("Verifying %s is rejected while its selector matches no pod", vip))
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			rejected, err := hasServiceRejectACL(f, svc.Spec.ClusterIP, jigServicePort)
+			return err == nil && rejected, nil
+		})
+		framework.ExpectNoError(err, "no reject ACL was programmed for %s without endpoints", vip)
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, vip, sets.NewString()))
+
+		By("Launching a pod matching the selector of the service")
+		_, err = jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the service backends")
+		backend := net.JoinHostPort(podList.Items[0].Status.PodIP, strconv.Itoa(jigServicePort))
+
+		By(fmt.Sprintf("Verifying %s load balances to %s without recreating the service", vip, backend))
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, vip, sets.NewString(backend)))
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			rejected, err := hasServiceRejectACL(f, svc.Spec.ClusterIP, jigServicePort)
+			return err == nil && !rejected, nil
+		})
+		framework.ExpectNoError(err, "the reject ACL of %s outlived its first backend", vip)
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+	})
+
+	It("Should preserve the DSCP marking of NodePort traffic through the gateway", func() {
+		const (
+			dscpName string = "af11"
+			dscp            = 10
+		)
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, found %d", len(nodes.Items))
+		}
+		clientNode, backendNode := nodes.Items[0], nodes.Items[1]
+		var backendNodeIP string
+		for _, address := range backendNode.Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				backendNodeIP = address.Address
+			}
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "dscp-svc")
+		_, err = jig.Run(func(rc *v1.ReplicationController) {
+			rc.Spec.Template.Spec.NodeName = backendNode.Name
+		})
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateTCPService(func(svc *v1.Service) {
+			svc.Spec.Type = v1.ServiceTypeNodePort
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+		nodePort := int(svc.Spec.Ports[0].NodePort)
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the service backends")
+		backend := &podList.Items[0]
+
+		By(fmt.Sprintf("Counting the packets backend %s receives, with and without DSCP %d", backend.Name, dscp))
+		port := strconv.Itoa(jigServicePort)
+		for _, rule := range [][]string{
+			{"-p", "tcp", "-m", "tcp", "--dport", port},
+			{"-p", "tcp", "-m", "tcp", "--dport", port, "-m", "dscp", "--dscp", strconv.Itoa(dscp)},
+		} {
+			out, err := runInPodNetns(backend, append([]string{"iptables", "-w", "-I", "INPUT"}, rule...)...)
+			framework.ExpectNoError(err, "failed to install a counting rule in backend %s: %s", backend.Name, out)
+		}
+
+		By(fmt.Sprintf("Sending marked traffic from node %s to NodePort %s:%d", clientNode.Name, backendNodeIP, nodePort))
+		client := newAgnhostPod("dscp-client", clientNode.Name, nil, "pause")
+		client.Spec.HostNetwork = true
+		f.PodClient().CreateSync(client)
+		cmd := fmt.Sprintf("for i in 1 2 3; do echo -e 'GET /hostname HTTP/1.0\\r\\n' | nc -T %s -w 2 %s %d; done", dscpName, backendNodeIP, nodePort)
+		out, err := framework.RunKubectl("exec", client.Name, "--namespace="+f.Namespace.Name, "--", "bash", "-c", cmd)
+		framework.ExpectNoError(err, "failed to send marked traffic to the NodePort: %s", out)
+		if !strings.Contains(out, backend.Name) {
+			framework.Failf("Backend %s did not answer the marked requests: %s", backend.Name, out)
+		}
+
+		By(fmt.Sprintf("Verifying backend %s received every packet with DSCP %d", backend.Name, dscp))
+		counters, err := getIptablesRuleCounters(backend, "INPUT")
+		framework.ExpectNoError(err, "failed to read the counting rules of backend %s", backend.Name)
+		var total, marked int
+		for rule, packets := range counters {
+			if !strings.Contains(rule, "--dport "+port) {
+				continue
+			}
+			if strings.Contains(rule, "--dscp") {
+				marked = packets
+			} else {
+				total = packets
+			}
+		}
+		if total == 0 || marked != total {
+			framework.Failf("Backend %s received %d packets of which only %d kept DSCP %d: %v", backend.Name, total, marked, dscp, counters)
+		}
+	})
+
+	It("Should keep the TCP and UDP ports of the same number of a service apart", func() {
+		const (
+			tcpServer string = "mixed-proto-tcp"
+			udpServer string = "mixed-proto-udp"
+			udpPort          = 8081
+		)
+		serverLabels := map[string]string{"app": "mixed-proto"}
+		// both servers answer TCP and UDP, but each only exposes the named port of one protocol so
+		// that the endpoints of the TCP port and of the UDP port of the service differ
+		var servers []*v1.Pod
+		for _, server := range []struct {
+			name string
+			port v1.ContainerPort
+		}{
+			{tcpServer, v1.ContainerPort{Name: "tcp", ContainerPort: netexecPort, Protocol: v1.ProtocolTCP}},
+			{udpServer, v1.ContainerPort{Name: "udp", ContainerPort: udpPort, Protocol: v1.ProtocolUDP}},
+		} {
+			pod := newAgnhostPod(server.name, "", serverLabels, "netexec",
+				fmt.Sprintf("--http-port=%d", netexecPort), fmt.Sprintf("--udp-port=%d", udpPort))
+			pod.Spec.Containers[0].Ports = []v1.ContainerPort{server.port}
+			servers = append(servers, f.PodClient().CreateSync(pod))
+		}
+
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mixed-proto-svc",
+			},
+			Spec: v1.ServiceSpec{
+				Selector: serverLabels,
+				Ports: []v1.ServicePort{
+					{Name: "tcp", Protocol: v1.ProtocolTCP, Port: jigServicePort, TargetPort: intstr.FromString("tcp")},
+					{Name: "udp", Protocol: v1.ProtocolUDP, Port: jigServicePort, TargetPort: intstr.FromString("udp")},
+				},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+
+		By(fmt.Sprintf("Verifying the TCP and UDP load balancers carry their own backends for %s", vip))
+		for protocol, backend := range map[v1.Protocol]string{
+			v1.ProtocolTCP: net.JoinHostPort(servers[0].Status.PodIP, strconv.Itoa(netexecPort)),
+			v1.ProtocolUDP: net.JoinHostPort(servers[1].Status.PodIP, strconv.Itoa(udpPort)),
+		} {
+			var backends []string
+			err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+				backends, err = getClusterLoadBalancerBackends(f, protocol, vip)
+				return err == nil && sets.NewString(backends...).Equal(sets.NewString(backend)), nil
+			})
+			framework.ExpectNoError(err, "%s load balancer has backends %v for %s, expected %s", protocol, backends, vip, backend)
+		}
+
+		By("Verifying TCP traffic only reaches the TCP server and UDP traffic only the UDP server")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		for i := 0; i < 5; i++ {
+			tcpAnswer, err := pokeHTTP(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+			framework.ExpectNoError(err, "TCP request to %s failed", vip)
+			if strings.TrimSpace(tcpAnswer) != tcpServer {
+				framework.Failf("TCP request to %s was answered by %q instead of %s", vip, tcpAnswer, tcpServer)
+			}
+			udpAnswer, err := framework.RunKubectl("exec", clientName, "--namespace="+f.Namespace.Name, "--", "bash", "-c",
+				fmt.Sprintf("echo -n hostname | nc -u -w 2 %s %d", svc.Spec.ClusterIP, jigServicePort))
+			framework.ExpectNoError(err, "UDP request to %s failed", vip)
+			if strings.TrimSpace(udpAnswer) != udpServer {
+				framework.Failf("UDP request to %s was answered by %q instead of %s", vip, udpAnswer, udpServer)
+			}
+		}
+	})
+
+	It("Should serve services without blackholes whether or not kube-proxy runs next to OVN", func() {
+		kubeProxy, err := kubeProxyRunning(f)
+		framework.ExpectNoError(err, "failed to look for kube-proxy")
+		framework.Logf("kube-proxy running: %v", kubeProxy)
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "mixed-mode-svc")
+		_, err = jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+
+		nodes, err := e2enode.GetReadySchedulableNodes(f.ClientSet)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		By(fmt.Sprintf("Verifying the kube-proxy rules for %s match the presence of kube-proxy", svc.Spec.ClusterIP))
+		for _, node := range nodes.Items {
+			var rules string
+			// kube-proxy may take a while to sync its rules, OVN never installs any
+			err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+				rules, err = runCommand("docker", "exec", node.Name, "iptables-save", "-t", "nat")
+				return err == nil && strings.Contains(rules, svc.Spec.ClusterIP) == kubeProxy, nil
+			})
+			framework.ExpectNoError(err, "node %s has nat rules for %s: %v, expected %v", node.Name, svc.Spec.ClusterIP,
+				strings.Contains(rules, svc.Spec.ClusterIP), kubeProxy)
+		}
+
+		By("Verifying the service is consistently reachable from pod and host network clients")
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		hostClient := newAgnhostPod("svc-host-client", "", nil, "pause")
+		hostClient.Spec.HostNetwork = true
+		f.PodClient().CreateSync(hostClient)
+		for _, client := range []string{clientName, hostClient.Name} {
+			expectConnectivity(f.Namespace.Name, client, svc.Spec.ClusterIP, jigServicePort)
+			for i := 0; i < 10; i++ {
+				_, err := pokeHTTP(f.Namespace.Name, client, svc.Spec.ClusterIP, jigServicePort)
+				framework.ExpectNoError(err, "request %d from %s to %s was blackholed", i+1, client, svc.Spec.ClusterIP)
+			}
+		}
+	})
+
+	It("Should move the load balancer VIP of a service when its port changes", func() {
+		const newPort = 8000
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "port-change-svc")
+		_, err := jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		oldVIP := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+		newVIP := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(newPort))
+		endpoints, err := getEndpointAddresses(f, f.Namespace.Name, svc.Name)
+		framework.ExpectNoError(err, "failed to get the endpoints of the service")
+
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, oldVIP, endpoints))
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+
+		By(fmt.Sprintf("Changing the port of the service from %d to %d", jigServicePort, newPort))
+		_, err = jig.UpdateService(func(svc *v1.Service) {
+			svc.Spec.Ports[0].Port = newPort
+		})
+		framework.ExpectNoError(err, "failed to change the port of the service")
+
+		By(fmt.Sprintf("Verifying the load balancer moved from %s to %s", oldVIP, newVIP))
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, newVIP, endpoints))
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, oldVIP, sets.NewString()))
+		expectConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, newPort)
+		expectNoConnectivity(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+	})
+
+	It("Should program ClusterIP VIPs on the node switches and NodePort VIPs on the gateway routers", func() {
+		dbPod, err := getOvnDBPodName(f)
+		framework.ExpectNoError(err, "failed to find an ovnkube-db pod")
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 {
+			framework.Skipf("Test requires a schedulable node")
+		}
+		var nodeIP string
+		for _, address := range nodes.Items[0].Status.Addresses {
+			if address.Type == v1.NodeInternalIP {
+				nodeIP = address.Address
+			}
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "lb-scope-svc")
+		_, err = jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateTCPService(func(svc *v1.Service) {
+			svc.Spec.Type = v1.ServiceTypeNodePort
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+
+		clusterIPVIP := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+		By(fmt.Sprintf("Verifying the ClusterIP VIP %s is only on the node switches", clusterIPVIP))
+		assertLBScope(dbPod, clusterIPVIP, lbScopeSwitch)
+
+		nodePortVIP := net.JoinHostPort(nodeIP, strconv.Itoa(int(svc.Spec.Ports[0].NodePort)))
+		By(fmt.Sprintf("Verifying the NodePort VIP %s is only on the gateway routers", nodePortVIP))
+		assertLBScope(dbPod, nodePortVIP, lbScopeGatewayRouter)
+	})
+
+	It("Should load balance a service exposing an SCTP port", func() {
+		const (
+			serverName string = "sctp-server"
+			sctpPort          = 5060
+		)
+		sctpLB, err := runNbctl(f, "--data=bare", "--no-heading", "--columns=_uuid", "find", "load_balancer", "external_ids:k8s-cluster-lb-sctp=yes")
+		framework.ExpectNoError(err, "failed to look for the SCTP load balancer")
+		if strings.TrimSpace(sctpLB) == "" {
+			framework.Skipf("Test requires SCTP support in ovn-kubernetes and Kubernetes")
+		}
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 1)
+		framework.ExpectNoError(err, "failed to list the schedulable nodes")
+		if len(nodes.Items) < 1 || !nodeSupportsSCTP(nodes.Items[0].Name) {
+			framework.Skipf("Test requires the sctp kernel module on the nodes")
+		}
+		serverLabels := map[string]string{"app": serverName}
+		// the server answers every association with its name
+		server := newSCTPPod(serverName, serverLabels, fmt.Sprintf("exec socat SCTP-LISTEN:%d,fork SYSTEM:hostname", sctpPort))
+		server.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: sctpPort, Protocol: v1.ProtocolSCTP}}
+		server = f.PodClient().CreateSync(server)
+
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "sctp-svc",
+			},
+			Spec: v1.ServiceSpec{
+				Selector: serverLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolSCTP, Port: sctpPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the SCTP service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(sctpPort))
+		backend := net.JoinHostPort(server.Status.PodIP, strconv.Itoa(sctpPort))
+
+		By(fmt.Sprintf("Verifying the SCTP load balancer balances %s to %s", vip, backend))
+		var backends []string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			backends, err = getClusterLoadBalancerBackends(f, v1.ProtocolSCTP, vip)
+			return err == nil && sets.NewString(backends...).Equal(sets.NewString(backend)), nil
+		})
+		framework.ExpectNoError(err, "SCTP load balancer has backends %v for %s, expected %s", backends, vip, backend)
+
+		f.PodClient().CreateSync(newSCTPPod(clientName, nil, "exec sleep 3600"))
+		By(fmt.Sprintf("Probing %s over SCTP", vip))
+		var answer string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			answer, err = probeSCTP(f.Namespace.Name, clientName, svc.Spec.ClusterIP, sctpPort)
+			return err == nil && strings.TrimSpace(answer) == serverName, nil
+		})
+		framework.ExpectNoError(err, "SCTP probe of %s answered %q instead of %s", vip, answer, serverName)
+	})
+
+	It("Should program and serve every port of a service with a long name and many ports", func() {
+		const (
+			portCount  = 100
+			firstPort  = 9000
+			serverName = "many-ports-server"
+		)
+		// service names are DNS labels of at most 63 characters
+		svcName := "long-svc-" + strings.Repeat("x", 63-len("long-svc-"))
+		serverLabels := map[string]string{"app": serverName}
+		var ports []int
+		var svcPorts []v1.ServicePort
+		for port := firstPort; port < firstPort+portCount; port++ {
+			ports = append(ports, port)
+			svcPorts = append(svcPorts, v1.ServicePort{Name: fmt.Sprintf("port-%d", port), Protocol: v1.ProtocolTCP, Port: int32(port)})
+		}
+		server := createMultiPortServerPod(f, f.Namespace.Name, serverName, serverLabels, ports)
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: svcName,
+			},
+			Spec: v1.ServiceSpec{
+				Selector: serverLabels,
+				Ports:    svcPorts,
+			},
+		})
+		framework.ExpectNoError(err, "failed to create service %s", svcName)
+
+		By(fmt.Sprintf("Verifying the %d VIPs of service %s are programmed", portCount, svcName))
+		for _, port := range ports {
+			vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(port))
+			backend := net.JoinHostPort(server.Status.PodIP, strconv.Itoa(port))
+			framework.ExpectNoError(waitForLoadBalancerBackends(f, vip, sets.NewString(backend)))
+		}
+
+		By(fmt.Sprintf("Probing each of the %d ports", portCount))
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		for _, port := range ports {
+			answer, err := pokeHTTP(f.Namespace.Name, clientName, svc.Spec.ClusterIP, port)
+			framework.ExpectNoError(err, "port %d of service %s is unreachable", port, svcName)
+			if want := fmt.Sprintf("%s-%d", serverName, port); strings.TrimSpace(answer) != want {
+				framework.Failf("Port %d of service %s answered %q instead of %q", port, svcName, answer, want)
+			}
+		}
+	})
+
+	It("Should reach an IPv4 only service over IPv4 from a dual-stack pod", func() {
+		client := createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		annotation, err := getPodNetworkAnnotation(f.Namespace.Name, clientName)
+		framework.ExpectNoError(err, "failed to get the network annotation of pod %s", clientName)
+		var clientIPv4 string
+		families := sets.NewString()
+		for _, ipNet := range annotation.IPs {
+			ip, _, err := net.ParseCIDR(ipNet)
+			framework.ExpectNoError(err, "invalid pod address %q", ipNet)
+			if ip.To4() != nil {
+				clientIPv4 = ip.String()
+				families.Insert(string(v1.IPv4Protocol))
+			} else {
+				families.Insert(string(v1.IPv6Protocol))
+			}
+		}
+		if families.Len() != 2 {
+			framework.Skipf("Test requires dual-stack pods, pod %s has addresses %v", client.Name, annotation.IPs)
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "v4-only-svc")
+		_, err = jig.Run(nil)
+		framework.ExpectNoError(err, "failed to run the service backend")
+		svc, err := jig.CreateTCPService(func(svc *v1.Service) {
+			family := v1.IPv4Protocol
+			svc.Spec.IPFamily = &family
+		})
+		framework.ExpectNoError(err, "failed to create the service")
+		if net.ParseIP(svc.Spec.ClusterIP).To4() == nil {
+			framework.Failf("IPv4 only service got the ClusterIP %s", svc.Spec.ClusterIP)
+		}
+
+		By(fmt.Sprintf("Verifying pod %s reaches %s from its IPv4 address %s", clientName, svc.Spec.ClusterIP, clientIPv4))
+		var seen string
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			seen, err = pokeClientIP(f.Namespace.Name, clientName, svc.Spec.ClusterIP, jigServicePort)
+			return err == nil, nil
+		})
+		framework.ExpectNoError(err, "pod %s did not reach the IPv4 only service %s", clientName, svc.Spec.ClusterIP)
+		if seen != clientIPv4 {
+			framework.Failf("Service %s saw pod %s connect from %s instead of its IPv4 address %s", svc.Spec.ClusterIP, clientName, seen, clientIPv4)
+		}
+	})
+
+	It("Should keep a service reachable while its PodDisruptionBudget protected backends are drained from a node", func() {
+		const probeClientName = "svc-drain-client"
+		nodes, err := e2enode.GetBoundedReadySchedulableNodes(f.ClientSet, 2)
+		framework.ExpectNoError(err, "failed to list the nodes")
+		if len(nodes.Items) < 2 {
+			framework.Skipf("Test requires at least 2 schedulable nodes, got %d", len(nodes.Items))
+		}
+
+		jig := e2eservice.NewTestJig(f.ClientSet, f.Namespace.Name, "drained-svc")
+		_, err = jig.Run(func(rc *v1.ReplicationController) {
+			count := int32(3)
+			rc.Spec.Replicas = &count
+		})
+		framework.ExpectNoError(err, "failed to run the service backends")
+		svc, err := jig.CreateTCPService(nil)
+		framework.ExpectNoError(err, "failed to create the service")
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(jigServicePort))
+
+		minAvailable := intstr.FromInt(2)
+		_, err = f.ClientSet.PolicyV1beta1().PodDisruptionBudgets(f.Namespace.Name).Create(&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "drained-svc",
+			},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				MinAvailable: &minAvailable,
+				Selector:     &metav1.LabelSelector{MatchLabels: jig.Labels},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create the PodDisruptionBudget")
+
+		podList, err := f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the service backends")
+		drainedNode := podList.Items[0].Spec.NodeName
+		probeNode := nodes.Items[0].Name
+		if probeNode == drainedNode {
+			probeNode = nodes.Items[1].Name
+		}
+
+		createProbeLoggerPod(f, probeClientName, probeNode, vip)
+		err = wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+			records, err := getProbeRecords(f, probeClientName)
+			return err == nil && len(records) > 0 && records[len(records)-1].responder != "", nil
+		})
+		framework.ExpectNoError(err, "client %s never reached the service %s", probeClientName, vip)
+
+		By(fmt.Sprintf("Draining the service backends from node %s", drainedNode))
+		defer func() {
+			framework.RunKubectlOrDie("uncordon", drainedNode)
+		}()
+		records, err := drainNodeWhileProbing(f, drainedNode, jig.Labels, probeClientName)
+		framework.ExpectNoError(err, "failed to drain node %s", drainedNode)
+
+		By("Verifying the service stayed reachable throughout the drain")
+		if len(records) == 0 {
+			framework.Failf("Client %s made no request during the drain", probeClientName)
+		}
+		for i := 1; i < len(records); i++ {
+			// a single request may race the removal of an evicted backend, consecutive failures may not
+			if records[i-1].responder == "" && records[i].responder == "" {
+				framework.Failf("Service %s was unreachable from %d to %d during the drain of node %s", vip, records[i-1].at, records[i].at, drainedNode)
+			}
+		}
+		podList, err = f.ClientSet.CoreV1().Pods(f.Namespace.Name).List(metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(jig.Labels).String(),
+		})
+		framework.ExpectNoError(err, "failed to list the service backends")
+		for _, pod := range podList.Items {
+			if pod.Spec.NodeName == drainedNode {
+				framework.Failf("Backend %s is still on the drained node %s", pod.Name, drainedNode)
+			}
+		}
+	})
+
+	It("Should load balance a service across backends on OVN and hybrid overlay nodes", func() {
+		const backendName string = "hybrid-backend"
+		backendLabels := map[string]string{"app": backendName}
+		backends := createHybridServiceBackends(f, backendName, backendLabels)
+		if len(backends) == 0 {
+			framework.Skipf("Test requires both OVN and hybrid overlay nodes")
+		}
+		svc, err := f.ClientSet.CoreV1().Services(f.Namespace.Name).Create(&v1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: backendName,
+			},
+			Spec: v1.ServiceSpec{
+				Selector: backendLabels,
+				Ports:    []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: netexecPort}},
+			},
+		})
+		framework.ExpectNoError(err, "failed to create service %s", backendName)
+		vip := net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(netexecPort))
+
+		createClientPod(f, f.Namespace.Name, clientName, "", nil)
+		want := sets.NewString()
+		for _, backend := range backends {
+			By(fmt.Sprintf("Verifying backend %s on node %s is reachable directly", backend.Name, backend.Spec.NodeName))
+			expectConnectivity(f.Namespace.Name, clientName, backend.Status.PodIP, netexecPort)
+			want.Insert(net.JoinHostPort(backend.Status.PodIP, strconv.Itoa(netexecPort)))
+		}
+
+		By(fmt.Sprintf("Verifying the load balancer of %s holds both backends", vip))
+		framework.ExpectNoError(waitForLoadBalancerBackends(f, vip, want))
+
+		By(fmt.Sprintf("Verifying both backends answer requests to %s", vip))
+		answered := sets.NewString()
+		err = wait.PollImmediate(500*time.Millisecond, convergeTimeout, func() (bool, error) {
+			if answer, err := pokeHTTP(f.Namespace.Name, clientName, svc.Spec.ClusterIP, netexecPort); err == nil {
+				answered.Insert(strings.TrimSpace(answer))
+			}
+			return answered.HasAll(backends[0].Name, backends[1].Name), nil
+		})
+		framework.ExpectNoError(err, "service %s was only answered by %v", vip, answered.List())
+	})
+})
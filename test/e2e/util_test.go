@@ -0,0 +1,233 @@
+package e2e_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/kubernetes/test/e2e/framework"
+)
+
+const (
+	// namespace the ovn-kubernetes components are deployed in
+	ovnNamespace = "ovn-kubernetes"
+	// agnhost netexec default HTTP port
+	netexecPort = 8080
+	// interval between two connectivity probes
+	pokeInterval = 2 * time.Second
+	// time given to OVN to converge after a policy or service change
+	convergeTimeout = 30 * time.Second
+)
+
+// newAgnhostPod returns a pod running the agnhost image with the given args on the specified
+// node. An empty node name leaves the placement to the scheduler.
+func newAgnhostPod(podName, nodeName string, labels map[string]string, args ...string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   podName,
+			Labels: labels,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{
+					Name:  fmt.Sprintf("%s-container", podName),
+					Image: framework.AgnHostImage,
+					Args:  args,
+				},
+			},
+			NodeName:      nodeName,
+			RestartPolicy: v1.RestartPolicyNever,
+		},
+	}
+}
+
+// createServerPod creates a netexec server pod in the namespace and waits for it to be running
+func createServerPod(f *framework.Framework, namespace, podName, nodeName string, labels map[string]string) *v1.Pod {
+	pod := newAgnhostPod(podName, nodeName, labels, "netexec", fmt.Sprintf("--http-port=%d", netexecPort))
+	return f.PodClientNS(namespace).CreateSync(pod)
+}
+
+// createClientPod creates an idle pod in the namespace, used as the source of exec based probes
+func createClientPod(f *framework.Framework, namespace, podName, nodeName string, labels map[string]string) *v1.Pod {
+	pod := newAgnhostPod(podName, nodeName, labels, "pause")
+	return f.PodClientNS(namespace).CreateSync(pod)
+}
+
+// pokeHTTP execs curl in the source pod against the netexec hostname endpoint on host:port
+// and returns the name of the pod that answered.
+func pokeHTTP(srcNamespace, srcPodName, host string, port int) (string, error) {
+	url := fmt.Sprintf("http://%s/hostname", net.JoinHostPort(host, strconv.Itoa(port)))
+	return framework.RunKubectl("exec", srcPodName, "--namespace="+srcNamespace, "--",
+		"curl", "--connect-timeout", "2", "--max-time", "5", "-s", "-f", url)
+}
+
+// expectConnectivity polls until the source pod reaches host:port, failing the test if it never does
+func expectConnectivity(srcNamespace, srcPodName, host string, port int) {
+	var lastErr error
+	err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		_, lastErr = pokeHTTP(srcNamespace, srcPodName, host, port)
+		return lastErr == nil, nil
+	})
+	framework.ExpectNoError(err, "expected %s/%s to reach %s port %d: %v", srcNamespace, srcPodName, host, port, lastErr)
+}
+
+// expectNoConnectivity polls until the source pod can no longer reach host:port and then verifies
+// that it stays unreachable for a few more probes, failing the test otherwise.
+func expectNoConnectivity(srcNamespace, srcPodName, host string, port int) {
+	err := wait.PollImmediate(pokeInterval, convergeTimeout, func() (bool, error) {
+		_, err := pokeHTTP(srcNamespace, srcPodName, host, port)
+		return err != nil, nil
+	})
+	framework.ExpectNoError(err, "expected %s/%s not to reach %s port %d", srcNamespace, srcPodName, host, port)
+	for i := 0; i < 3; i++ {
+		time.Sleep(pokeInterval)
+		if out, err := pokeHTTP(srcNamespace, srcPodName, host, port); err == nil {
+			framework.Failf("Expected %s/%s not to reach %s port %d but %q answered", srcNamespace, srcPodName, host, port, out)
+		}
+	}
+}
+
+// runMeshConnectivity probes every target from every client pod hosted on a different node and
+// returns an error listing all the unreachable pairs. clients and targets are keyed by node name,
+// clients holding pod names in the namespace and targets holding IP addresses.
+func runMeshConnectivity(namespace string, clients, targets map[string]string, port int) error {
+	var failures []string
+	for srcNode, srcPod := range clients {
+		for dstNode, dstIP := range targets {
+			if srcNode == dstNode {
+				continue
+			}
+			if _, err := pokeHTTP(namespace, srcPod, dstIP, port); err != nil {
+				failures = append(failures, fmt.Sprintf("%s (%s) -> %s (%s): %v", srcPod, srcNode, dstIP, dstNode, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d node pairs are not connected:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// podNetworkAnnotation is the subset of the k8s.ovn.org/pod-networks annotation used by the tests
+type podNetworkAnnotation struct {
+	IPs      []string `json:"ip_addresses"`
+	MAC      string   `json:"mac_address"`
+	Gateways []string `json:"gateway_ips,omitempty"`
+}
+
+// getPodNetworkAnnotation returns the default network entry of the pod's k8s.ovn.org/pod-networks annotation
+func getPodNetworkAnnotation(namespace, podName string) (*podNetworkAnnotation, error) {
+	jsonFlag := "jsonpath='{.metadata.annotations.k8s\\.ovn\\.org/pod-networks}'"
+	kubectlOut, err := framework.RunKubectl("get", "pod", podName, "--namespace="+namespace, "-o", jsonFlag)
+	if err != nil {
+		return nil, err
+	}
+	annotation := strings.Trim(kubectlOut, "'")
+	networks := make(map[string]podNetworkAnnotation)
+	if err := json.Unmarshal([]byte(annotation), &networks); err != nil {
+		return nil, fmt.Errorf("failed to parse the pod-networks annotation %q of pod %s/%s: %v", annotation, namespace, podName, err)
+	}
+	network, ok := networks["default"]
+	if !ok {
+		return nil, fmt.Errorf("pod %s/%s has no default network in its pod-networks annotation %q", namespace, podName, annotation)
+	}
+	return &network, nil
+}
+
+// getOvnDBPodName returns the name of an ovnkube-db pod. Any raft member will do as the ctl
+// commands are run with --no-leader-only.
+func getOvnDBPodName(f *framework.Framework) (string, error) {
+	podList, err := f.ClientSet.CoreV1().Pods(ovnNamespace).List(metav1.ListOptions{LabelSelector: "name=ovnkube-db"})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase == v1.PodRunning {
+			return pod.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no running ovnkube-db pod found in namespace %s", ovnNamespace)
+}
+
+// runNbctl runs ovn-nbctl with the given arguments against the northbound database and returns its output
+func runNbctl(f *framework.Framework, args ...string) (string, error) {
+	dbPod, err := getOvnDBPodName(f)
+	if err != nil {
+		return "", err
+	}
+	return runNbctlInPod(dbPod, args...)
+}
+
+// runNbctlInPod runs ovn-nbctl with the given arguments in the nb-ovsdb container of the ovnkube-db pod
+func runNbctlInPod(dbPod string, args ...string) (string, error) {
+	cmd := append([]string{"exec", dbPod, "--namespace=" + ovnNamespace, "--container=nb-ovsdb", "--",
+		"ovn-nbctl", "--no-leader-only"}, args...)
+	return framework.RunKubectl(cmd...)
+}
+
+// runSbctl runs ovn-sbctl with the given arguments against the southbound database and returns its output
+func runSbctl(f *framework.Framework, args ...string) (string, error) {
+	dbPod, err := getOvnDBPodName(f)
+	if err != nil {
+		return "", err
+	}
+	cmd := append([]string{"exec", dbPod, "--namespace=" + ovnNamespace, "--container=sb-ovsdb", "--",
+		"ovn-sbctl", "--no-leader-only"}, args...)
+	return framework.RunKubectl(cmd...)
+}
+
+// getOvnConfig returns the value of key in the ovn-config ConfigMap the cluster was deployed with
+func getOvnConfig(f *framework.Framework, key string) (string, error) {
+	cm, err := f.ClientSet.CoreV1().ConfigMaps(ovnNamespace).Get("ovn-config", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	value, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in the ovn-config ConfigMap", key)
+	}
+	return value, nil
+}
+
+// runInPodNetns runs the command in the network namespace of the pod from its KIND node container,
+// giving access to tools such as iptables the agnhost image lacks.
+func runInPodNetns(pod *v1.Pod, cmd ...string) (string, error) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return "", fmt.Errorf("pod %s/%s has no container status", pod.Namespace, pod.Name)
+	}
+	pid, err := getContainerPid(pod, pod.Status.ContainerStatuses[0].Name)
+	if err != nil {
+		return "", err
+	}
+	nsenter := []string{"docker", "exec", pod.Spec.NodeName, "nsenter", "--net=/proc/" + pid + "/ns/net", "--"}
+	return runCommand(append(nsenter, cmd...)...)
+}
+
+// getContainerPid returns the pid, as seen from its KIND node container, of the named container
+// of the pod.
+func getContainerPid(pod *v1.Pod, containerName string) (string, error) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != containerName {
+			continue
+		}
+		// container IDs look like containerd://<id>, and are empty until the container started
+		sep := strings.Index(status.ContainerID, "://")
+		if sep < 0 {
+			return "", fmt.Errorf("container %s of pod %s/%s has no container ID %q", containerName, pod.Namespace, pod.Name, status.ContainerID)
+		}
+		containerID := status.ContainerID[sep+3:]
+		pid, err := runCommand("docker", "exec", pod.Spec.NodeName, "crictl", "inspect",
+			"--output", "go-template", "--template", "{{.info.pid}}", containerID)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(pid), nil
+	}
+	return "", fmt.Errorf("pod %s/%s has no status for container %s", pod.Namespace, pod.Name, containerName)
+}